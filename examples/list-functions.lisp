@@ -9,7 +9,7 @@
         (t (drop (cdr lst) (1- n)))))
 
 (defun first-half (lst)
-    (take lst (/ (length lst) 2)))
+    (take lst (floor (length lst) 2)))
 
 (defun second-half (lst)
-    (drop lst (/ (length lst) 2)))
\ No newline at end of file
+    (drop lst (floor (length lst) 2)))
\ No newline at end of file
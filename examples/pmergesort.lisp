@@ -4,6 +4,6 @@
   (cond 
     ((eq (length lst) 1) lst)
     (t {merge 
-            (mergesort (take lst (/ (length lst) 2)))
-            (mergesort (drop lst (/ (length lst) 2)))}
+            (mergesort (take lst (floor (length lst) 2)))
+            (mergesort (drop lst (floor (length lst) 2)))}
         )))
\ No newline at end of file
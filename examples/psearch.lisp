@@ -5,9 +5,9 @@
         ((eq lst nil) nil)
         ((eq (length lst) 1) 
             (eq (car lst) x))
-        (t {or 
+        (t (por 
                 (psearch x (first-half lst))
-                (psearch x (second-half lst))}
+                (psearch x (second-half lst)))
         )))
 
 (defun lib-psearch (x myList) 
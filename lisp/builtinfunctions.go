@@ -2,48 +2,60 @@ package lisp
 
 import (
 	"fmt"
-	"io/ioutil"
+	"io"
+	"os"
 	"time"
 )
 
-func condMacro(args Cell, env *environmentEntry) EvalResult {
+func condMacro(args Cell, env *environmentEntry, t *task) (tailCall, error) {
 	actBranch := args
 	var condAndBody Cell
 	var cond Cell
 	var body Cell
 	var condResult EvalResult
 	for actBranch != nil {
+		if err := t.interruption(); err != nil {
+			return tailCall{}, err
+		}
 		condAndBody = car(actBranch)
+		if !isClause(condAndBody) {
+			return tailCall{}, newTypeError("cond", condAndBody, "a clause")
+		}
 		cond = car(condAndBody)
 		body = cadr(condAndBody)
-		condResult = eval(cond, env)
+		condResult = eval(cond, env, t)
+		if condResult.Err == nil {
+			condResult = touch(condResult.Cell, t)
+		}
 		if condResult.Err != nil {
-			return condResult
+			return tailCall{}, condResult.Err
 		} else if condResult.Cell != nil {
-			return eval(body, env)
+			return tailCall{body, env, t}, nil
 		}
 		actBranch = cdr(actBranch)
 	}
-	return newEvalErrorResult(newEvalError("[cond] none condition was verified"))
+	return tailCall{}, newEvalError("[cond] none condition was verified")
 }
 
-func quoteMacro(args Cell, env *environmentEntry) EvalResult {
-	switch cons := args.(type) {
-	case *consCell:
-		return newEvalPositiveResult(cons.Car)
-	default:
-		return newEvalErrorResult(newEvalError("[quote] Can't quote" + fmt.Sprint(cons)))
+// isClause tells if the cell is one list of one condition and one body
+func isClause(c Cell) bool {
+	cons, isCons := c.(*consCell)
+	if !isCons {
+		return false
 	}
+	_, hasBody := cons.Cdr.(*consCell)
+	return hasBody
 }
 
-func timeMacro(args Cell, env *environmentEntry) EvalResult {
-	if args == nil {
-		return newEvalErrorResult(newEvalError("[time] too few arguments"))
-	}
+func quoteMacro(args Cell, env *environmentEntry, t *task) EvalResult {
+	return newEvalPositiveResult(car(args))
+}
+
+func timeMacro(args Cell, env *environmentEntry, t *task) EvalResult {
 	now := time.Now()
 	start := now.UnixNano()
 
-	result := eval(car(args), env)
+	result := eval(car(args), env, t)
 	if result.Err != nil {
 		return result
 	}
@@ -51,190 +63,315 @@ func timeMacro(args Cell, env *environmentEntry) EvalResult {
 	now = time.Now()
 	afterEvalTime := now.UnixNano()
 	elapsedMillis := (afterEvalTime - start) / 1000000
-	fmt.Println("time:", elapsedMillis, "ms")
+	fmt.Fprintln(t.interp.config.Output, "time:", elapsedMillis, "ms")
 
 	return result
 }
 
-func lambdaMacro(args Cell, env *environmentEntry) EvalResult {
-	// lambda autoquote
-	return newEvalPositiveResult(makeCons(makeSymbol("lambda"), args))
+// profileMacro prints the statistics of the {} forms evaluated by the expression
+func profileMacro(args Cell, env *environmentEntry, t *task) EvalResult {
+	prof := newProfiler()
+	result := eval(car(args), env, t.withProfiler(prof))
+	prof.report(t.interp.config.Output)
+	return result
 }
 
-func defunMacro(args Cell, env *environmentEntry) EvalResult {
-	argsSlice := extractCars(args)
-	if len(argsSlice) != 3 {
-		return newEvalErrorResult(newEvalError("[defun] wrong number of arguments"))
+// withTraceMacro writes to the file the trace of the evaluation of the expression
+func withTraceMacro(args Cell, env *environmentEntry, t *task) EvalResult {
+	fileName := eval(car(args), env, t)
+	if fileName.Err != nil {
+		return fileName
 	}
+	path, isString := fileName.Cell.(*stringCell)
+	if !isString {
+		return newEvalErrorResult(newTypeError("with-trace", fileName.Cell, "a string"))
+	}
+	tr := newTracer()
+	result := eval(cadr(args), env, t.withTracer(tr))
+	if err := tr.writeFile(path.Str); err != nil {
+		return newEvalErrorResult(newEvalError("[with-trace] " + err.Error()))
+	}
+	return result
+}
+
+// workSpanMacro prints the work and the span of the evaluation of the expression
+func workSpanMacro(args Cell, env *environmentEntry, t *task) EvalResult {
+	measured := t.withStrand(newStrand())
+	result := eval(car(args), env, measured)
+	if result.Err == nil {
+		result = touch(result.Cell, measured)
+	}
+	if result.Err != nil {
+		return result
+	}
+	measured.strand.pause()
+	workSpanReport(t.interp.config.Output, *measured.strand.work, measured.strand.span)
+	return result
+}
+
+// simulateMacro prints the speedup the evaluation of the expression would
+// have on more cpus, simulating its fork/join graph
+func simulateMacro(args Cell, env *environmentEntry, t *task) EvalResult {
+	graphStrand := newStrand()
+	graphStrand.graph = &forkJoinGraph{}
+	measured := t.withStrand(graphStrand)
+	result := eval(car(args), env, measured)
+	if result.Err == nil {
+		result = touch(result.Cell, measured)
+	}
+	if result.Err != nil {
+		return result
+	}
+	measured.strand.pause()
+	simulationReport(t.interp.config.Output, graphStrand.graph, *graphStrand.work, graphStrand.span)
+	return result
+}
+
+func futureMacro(args Cell, env *environmentEntry, t *task) EvalResult {
+	return newEvalPositiveResult(spawnFuture(car(args), env, t))
+}
+
+func lambdaMacro(args Cell, env *environmentEntry, t *task) EvalResult {
+	if err := checkParameters("lambda", car(args)); err != nil {
+		return newEvalErrorResult(err)
+	}
+	return newEvalPositiveResult(makeClosure(car(args), cadr(args), env))
+}
+
+func defunMacro(args Cell, env *environmentEntry, t *task) EvalResult {
+	argsSlice := extractCars(args)
 	name := argsSlice[0]
 	formalParameters := argsSlice[1]
 	lambdaBody := argsSlice[2]
-	bodyCons := makeCons(lambdaBody, nil)
-	argsAndBodyCons := makeCons(formalParameters, bodyCons)
-	ret := makeCons(makeSymbol("lambda"), argsAndBodyCons)
+	if err := checkParameters("defun", formalParameters); err != nil {
+		return newEvalErrorResult(err)
+	}
+	ret := makeClosure(formalParameters, lambdaBody, env)
 	switch nameSymbolCell := name.(type) {
 	case *symbolCell:
-		globalEnv[nameSymbolCell.Sym] = ret
+		t.define(nameSymbolCell.Sym, ret)
 	default:
-		return newEvalErrorResult(newEvalError("[defun] the name of the lambda must be a symbol"))
+		return newEvalErrorResult(newTypeError("defun", name, "a symbol"))
 	}
 	return newEvalPositiveResult(ret)
 }
 
-func setqMacro(args Cell, env *environmentEntry) EvalResult {
-	argsSlice := extractCars(args)
-	if len(argsSlice) != 2 {
-		return newEvalErrorResult(newEvalError("[setq] wrong number of arguments"))
+// checkParameters checks that the formal parameters are one list of symbols
+func checkParameters(name string, params Cell) error {
+	for act := params; act != nil; act = cdr(act) {
+		if _, isCons := act.(*consCell); !isCons {
+			return newTypeError(name, params, "a list of parameters")
+		}
+		if _, isSymbol := car(act).(*symbolCell); !isSymbol {
+			return newTypeError(name, car(act), "a symbol")
+		}
 	}
+	return nil
+}
+
+func setqMacro(args Cell, env *environmentEntry, t *task) EvalResult {
+	argsSlice := extractCars(args)
 	name := argsSlice[0]
 	value := argsSlice[1]
 
 	switch name.(type) {
 	case (*symbolCell):
-		evaluedVal := eval(value, env)
+		evaluedVal := eval(value, env, t)
 		if evaluedVal.Err != nil {
 			return evaluedVal
 		}
 		newArgs := makeCons(name, makeCons(evaluedVal.Cell, nil))
-		return setLambda(newArgs, env)
+		return setLambda(newArgs, env, t)
 	default:
-		return newEvalErrorResult(newEvalError("[setq] first argument must be a symbol"))
+		return newEvalErrorResult(newTypeError("setq", name, "a symbol"))
+	}
+}
+
+func defvarMacro(args Cell, env *environmentEntry, t *task) EvalResult {
+	return defineSpecial("defvar", false, args, env, t)
+}
+
+func defparameterMacro(args Cell, env *environmentEntry, t *task) EvalResult {
+	return defineSpecial("defparameter", true, args, env, t)
+}
+
+// defineSpecial declares the symbol as special: it gets bound dynamically
+// by let and lambdas. The value is assigned if overwrite is set or if the
+// symbol has no global value yet
+func defineSpecial(macroName string, overwrite bool, args Cell, env *environmentEntry, t *task) EvalResult {
+	argsSlice := extractCars(args)
+	sym, isSymbol := argsSlice[0].(*symbolCell)
+	if !isSymbol {
+		return newEvalErrorResult(newTypeError(macroName, argsSlice[0], "a symbol"))
+	}
+	var value Cell
+	hasValue := false
+	if _, isBound := t.globals.lookup(sym.Sym); len(argsSlice) == 2 && (overwrite || !isBound) {
+		evaluedVal := eval(argsSlice[1], env, t)
+		if evaluedVal.Err != nil {
+			return evaluedVal
+		}
+		value, hasValue = evaluedVal.Cell, true
 	}
+	t.globals = t.interp.globalEnv.declareSpecial(sym.Sym, value, hasValue, overwrite)
+	return newEvalPositiveResult(sym)
 }
 
-func letMacro(args Cell, env *environmentEntry) EvalResult {
+func letMacro(args Cell, env *environmentEntry, t *task) (tailCall, error) {
 	pairs := car(args)
 	newEnv := env
+	newDynamic := t.dynamic
 	for pairs != nil {
-		evaluedValue := eval(cadar(pairs), env)
+		if err := t.interruption(); err != nil {
+			return tailCall{}, err
+		}
+		sym, value, err := binding("let", pairs)
+		if err != nil {
+			return tailCall{}, err
+		}
+		evaluedValue := eval(value, env, t)
 		if evaluedValue.Err != nil {
-			return evaluedValue
+			return tailCall{}, evaluedValue.Err
+		}
+		if t.globals.isSpecialSymbol(sym) {
+			newDynamic = newEnvironmentEntry(sym, evaluedValue.Cell, newDynamic)
+		} else {
+			newEnv = newEnvironmentEntry(sym, evaluedValue.Cell, newEnv)
 		}
-		newEnv = newEnvironmentEntry(caar(pairs).(*symbolCell), evaluedValue.Cell, newEnv)
 		pairs = cdr(pairs)
 	}
-	return eval(cadr(args), newEnv)
+	return tailCall{cadr(args), newEnv, t.withDynamic(newDynamic)}, nil
 }
 
-func dotimesMacro(args Cell, env *environmentEntry) EvalResult {
+// pletMacro evaluates the values of the bindings in parallel, like the
+// arguments of one {} form
+func pletMacro(args Cell, env *environmentEntry, t *task) (tailCall, error) {
+	var symbols, lastSymbol Cell
+	var values, lastValue Cell
+	for pairs := car(args); pairs != nil; pairs = cdr(pairs) {
+		sym, value, err := binding("plet", pairs)
+		if err != nil {
+			return tailCall{}, err
+		}
+		symCell := Cell(sym)
+		appendCellToArgs(&symbols, &lastSymbol, &symCell)
+		appendCellToArgs(&values, &lastValue, &value)
+	}
+	evaluedValues := evlisParallel("plet", values, env, t, nil)
+	if evaluedValues.Err != nil {
+		return tailCall{}, evaluedValues.Err
+	}
+	newEnv, newTask, err := pairlis(symbols, evaluedValues.Cell, env, t)
+	if err != nil {
+		return tailCall{}, err
+	}
+	return tailCall{cadr(args), newEnv, newTask}, nil
+}
+
+// binding returns the symbol and the expression of the first binding of the
+// list of the bindings of one let
+func binding(name string, bindings Cell) (*symbolCell, Cell, error) {
+	if _, isCons := bindings.(*consCell); !isCons {
+		return nil, nil, newTypeError(name, bindings, "a list of bindings")
+	}
+	pair := car(bindings)
+	if !isClause(pair) || cddr(pair) != nil {
+		return nil, nil, newTypeError(name, pair, "a binding")
+	}
+	sym, isSymbol := car(pair).(*symbolCell)
+	if !isSymbol {
+		return nil, nil, newTypeError(name, car(pair), "a symbol")
+	}
+	return sym, cadr(pair), nil
+}
+
+func dotimesMacro(args Cell, env *environmentEntry, t *task) EvalResult {
 	firstArg := car(args)
 	body := cadr(args)
-	varName := car(firstArg)
-	varValue := cadr(firstArg)
-	for i := 0; i < (varValue.(*intCell)).Val; i++ {
-		newEnv := newEnvironmentEntry(varName.(*symbolCell), makeInt(i), env)
-		eval(body, newEnv)
+	if !isClause(firstArg) || cddr(firstArg) != nil {
+		return newEvalErrorResult(newTypeError("dotimes", firstArg, "(variable count)"))
+	}
+	varName, isSymbol := car(firstArg).(*symbolCell)
+	if !isSymbol {
+		return newEvalErrorResult(newTypeError("dotimes", car(firstArg), "a symbol"))
+	}
+	count := eval(cadr(firstArg), env, t)
+	if count.Err != nil {
+		return count
+	}
+	n, isInt := count.Cell.(*intCell)
+	if !isInt {
+		return newEvalErrorResult(newTypeError("dotimes", count.Cell, "a fixnum"))
+	}
+	for i := 0; i < n.Val; i++ {
+		if err := t.interruption(); err != nil {
+			return newEvalErrorResult(err)
+		}
+		newEnv := newEnvironmentEntry(varName, makeInt(i), env)
+		if res := eval(body, newEnv, t); res.Err != nil {
+			return res
+		}
 	}
 	return newEvalPositiveResult(nil)
 }
 
-func carLambda(args Cell, env *environmentEntry) EvalResult {
-	switch topCons := args.(type) {
-	case *consCell:
-		switch cons := topCons.Car.(type) {
-		case *consCell:
-			return newEvalResult(cons.Car, nil)
-		default:
-			return newEvalResult(nil, newEvalError("[car] car applied to atom"))
-		}
-	default:
-		return newEvalResult(nil, newEvalError("[car] not enough arguments"))
+func carLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	cons, isCons := car(args).(*consCell)
+	if !isCons {
+		return newEvalErrorResult(newTypeError("car", car(args), "a cons"))
 	}
+	return newEvalPositiveResult(cons.Car)
 }
 
-func cdrLambda(args Cell, env *environmentEntry) EvalResult {
-	switch topCons := args.(type) {
-	case *consCell:
-		switch cons := topCons.Car.(type) {
-		case *consCell:
-			return newEvalResult(cons.Cdr, nil)
-		default:
-			return newEvalResult(nil, newEvalError("[cdr] cdr applied to atom"))
-		}
-	default:
-		return newEvalResult(nil, newEvalError("[cdr] not enough arguments"))
+func cdrLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	cons, isCons := car(args).(*consCell)
+	if !isCons {
+		return newEvalErrorResult(newTypeError("cdr", car(args), "a cons"))
 	}
+	return newEvalPositiveResult(cons.Cdr)
 }
 
-func consLambda(args Cell, env *environmentEntry) EvalResult {
-	switch firstCons := args.(type) {
-	case *consCell:
-		switch cons := firstCons.Cdr.(type) {
-		case *consCell:
-			return newEvalPositiveResult(makeCons(firstCons.Car, cons.Car))
-		default:
-			return newEvalErrorResult(newEvalError("[cons] not enough arguments"))
-		}
-	default:
-		return newEvalErrorResult(newEvalError("[cons] not enough arguments"))
-	}
+func consLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	return newEvalPositiveResult(makeCons(car(args), cadr(args)))
 }
 
-func eqLambda(args Cell, env *environmentEntry) EvalResult {
-	switch firstArg := args.(type) {
-	case *consCell:
-		switch secondArg := firstArg.Cdr.(type) {
-		case *consCell:
-			if eq(firstArg.Car, secondArg.Car) {
-				return newEvalPositiveResult(lisp.getTrueSymbol())
-			}
-			return newEvalPositiveResult(nil)
-		default:
-			return newEvalErrorResult(newEvalError("[eq] not enough arguments"))
-		}
-	default:
-		return newEvalErrorResult(newEvalError("[eq] not enough arguments"))
+func eqLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	if eq(car(args), cadr(args)) {
+		return newEvalPositiveResult(t.lang().getTrueSymbol())
 	}
+	return newEvalPositiveResult(nil)
 }
 
-func atomLambda(args Cell, env *environmentEntry) EvalResult {
+func atomLambda(args Cell, env *environmentEntry, t *task) EvalResult {
 	switch firstCons := args.(type) {
 	case *consCell:
 		switch firstCons.Car.(type) {
 		case *consCell:
 			return newEvalPositiveResult(nil)
 		default:
-			return newEvalPositiveResult(lisp.getTrueSymbol())
+			return newEvalPositiveResult(t.lang().getTrueSymbol())
 		}
 	default:
 		return newEvalErrorResult(newEvalError("[atom] not enough arguments"))
 	}
 }
 
-func plusLambda(args Cell, env *environmentEntry) EvalResult {
-	tot := 0
-	act := args
-	for act != nil {
-		tot += (car(act).(*intCell)).Val
-		act = cdr(act)
-	}
-	return newEvalPositiveResult(makeInt(tot))
+func plusLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	return foldNumbers("+", makeInt(0), args, addition)
 }
 
-func multLambda(args Cell, env *environmentEntry) EvalResult {
-	tot := 1
-	act := args
-	for act != nil {
-		tot *= (car(act).(*intCell)).Val
-		act = cdr(act)
-	}
-	return newEvalPositiveResult(makeInt(tot))
+func multLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	return foldNumbers("*", makeInt(1), args, multiplication)
 }
 
-func minusLambda(args Cell, env *environmentEntry) EvalResult {
-	if args == nil {
-		return newEvalErrorResult(newEvalError("[-] too few arguments"))
-	}
-	tot := (car(args).(*intCell)).Val
-	act := cdr(args)
-	for act != nil {
-		tot -= (car(act).(*intCell)).Val
-		act = cdr(act)
+func minusLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	if !isNumber(car(args)) {
+		return newEvalErrorResult(notANumber("-", car(args)))
 	}
-	return newEvalPositiveResult(makeInt(tot))
+	return foldNumbers("-", car(args), cdr(args), subtraction)
 }
 
-func orLambda(args Cell, env *environmentEntry) EvalResult {
+func orLambda(args Cell, env *environmentEntry, t *task) EvalResult {
 	act := args
 	for act != nil {
 		if car(act) != nil {
@@ -245,7 +382,7 @@ func orLambda(args Cell, env *environmentEntry) EvalResult {
 	return newEvalPositiveResult(nil)
 }
 
-func andLambda(args Cell, env *environmentEntry) EvalResult {
+func andLambda(args Cell, env *environmentEntry, t *task) EvalResult {
 	act := args
 	var last Cell
 	for act != nil {
@@ -258,79 +395,154 @@ func andLambda(args Cell, env *environmentEntry) EvalResult {
 	return newEvalPositiveResult(last)
 }
 
-func notLambda(args Cell, env *environmentEntry) EvalResult {
+func porMacro(args Cell, env *environmentEntry, t *task) EvalResult {
+	if args == nil {
+		return newEvalPositiveResult(nil)
+	}
+	return speculate("por", args, env, t, func(c Cell) bool { return c != nil })
+}
+
+func pandMacro(args Cell, env *environmentEntry, t *task) EvalResult {
+	if args == nil {
+		return newEvalPositiveResult(t.lang().getTrueSymbol())
+	}
+	return speculate("pand", args, env, t, func(c Cell) bool { return c == nil })
+}
+
+func notLambda(args Cell, env *environmentEntry, t *task) EvalResult {
 	toNegate := car(args)
 	if toNegate == nil {
-		return newEvalPositiveResult(lisp.getTrueSymbol())
+		return newEvalPositiveResult(t.lang().getTrueSymbol())
 	}
 	return newEvalPositiveResult(nil)
 }
 
-func greaterLambda(args Cell, env *environmentEntry) EvalResult {
-	return listRelationalComparison(args, env, func(left, right int) bool { return left > right })
+func greaterLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	return listRelationalComparison(">", args, env, t, func(comparison int) bool { return comparison > 0 })
 }
 
-func greaterEqLambda(args Cell, env *environmentEntry) EvalResult {
-	return listRelationalComparison(args, env, func(left, right int) bool { return left >= right })
+func greaterEqLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	return listRelationalComparison(">=", args, env, t, func(comparison int) bool { return comparison >= 0 })
 }
 
-func lessLambda(args Cell, env *environmentEntry) EvalResult {
-	return listRelationalComparison(args, env, func(left, right int) bool { return left < right })
+func lessLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	return listRelationalComparison("<", args, env, t, func(comparison int) bool { return comparison < 0 })
 }
 
-func lessEqLambda(args Cell, env *environmentEntry) EvalResult {
-	return listRelationalComparison(args, env, func(left, right int) bool { return left <= right })
+func lessEqLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	return listRelationalComparison("<=", args, env, t, func(comparison int) bool { return comparison <= 0 })
 }
 
-func listRelationalComparison(args Cell, env *environmentEntry, operator func(int, int) bool) EvalResult {
+// listRelationalComparison checks that the operator holds on the result of
+// compareNumbers for every couple of adjacent arguments
+func listRelationalComparison(name string, args Cell, env *environmentEntry, t *task, operator func(int) bool) EvalResult {
+	for act := args; act != nil; act = cdr(act) {
+		if !isNumber(car(act)) {
+			return newEvalErrorResult(notANumber(name, car(act)))
+		}
+	}
 	act := cdr(args)
 	last := car(args)
 	for act != nil {
-		if !(operator((last.(*intCell)).Val, (car(act).(*intCell)).Val)) {
+		if !operator(compareNumbers(last, car(act))) {
 			return newEvalPositiveResult(nil)
 		}
 		last = car(act)
 		act = cdr(act)
 	}
-	return newEvalPositiveResult(lisp.getTrueSymbol())
+	return newEvalPositiveResult(t.lang().getTrueSymbol())
 }
 
-func divLambda(args Cell, env *environmentEntry) EvalResult {
-	if args == nil {
-		return newEvalErrorResult(newEvalError("[/] too few arguments"))
+func divLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	if !isNumber(car(args)) {
+		return newEvalErrorResult(notANumber("/", car(args)))
 	}
-	tot := (car(args).(*intCell)).Val
-	act := cdr(args)
-	div := 0
-	for act != nil {
-		div = (car(act).(*intCell)).Val
-		if div == 0 {
+	for act := cdr(args); act != nil; act = cdr(act) {
+		if isZero(car(act)) {
 			return newEvalErrorResult(newEvalError("[/] division by zero"))
 		}
-		tot /= div
-		act = cdr(act)
 	}
-	return newEvalPositiveResult(makeInt(tot))
+	return foldNumbers("/", car(args), cdr(args), division)
 }
 
-func loadLambda(args Cell, env *environmentEntry) EvalResult {
-	files := extractCars(args)
-	if len(files) != 1 {
-		return newEvalErrorResult(newEvalError("[load] load needs exaclty one argument"))
+func floorLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	return roundNumber("floor", args, floorRounding)
+}
+
+func ceilingLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	return roundNumber("ceiling", args, ceilingRounding)
+}
+
+func roundLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	return roundNumber("round", args, roundRounding)
+}
+
+func truncateLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	return roundNumber("truncate", args, truncateRounding)
+}
+
+// roundNumber rounds the first argument, divided by the second if present,
+// to one integer
+func roundNumber(name string, args Cell, r rounding) EvalResult {
+	number := car(args)
+	if !isNumber(number) {
+		return newEvalErrorResult(notANumber(name, number))
 	}
-	fileName := (files[0].(*stringCell)).Str
-	dat, err := ioutil.ReadFile(fileName)
-	if err != nil {
-		return newEvalErrorResult(newEvalError("[load] error opening file " + fileName))
+	if cdr(args) != nil {
+		divisor := cadr(args)
+		if !isNumber(divisor) {
+			return newEvalErrorResult(notANumber(name, divisor))
+		}
+		if isZero(divisor) {
+			return newEvalErrorResult(newEvalError("[" + name + "] division by zero"))
+		}
+		number = division.apply(number, divisor)
 	}
-	source := string(dat)
-	sexpressions, err := parseMultipleSexpressions(source)
+	rounded, err := r.apply(name, number)
 	if err != nil {
 		return newEvalErrorResult(err)
 	}
+	return newEvalPositiveResult(rounded)
+}
+
+func floatLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	if !isNumber(car(args)) {
+		return newEvalErrorResult(notANumber("float", car(args)))
+	}
+	return newEvalPositiveResult(makeFloat(toFloat(car(args))))
+}
+
+func loadLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	file, isString := car(args).(*stringCell)
+	if !isString {
+		return newEvalErrorResult(newTypeError("load", car(args), "a string"))
+	}
+	fileName := file.Str
+	source, err := os.Open(fileName)
+	if err != nil {
+		return newEvalErrorResult(newEvalError("[load] error opening file " + fileName))
+	}
+	defer source.Close()
+	r := newReader(t.lang(), source, Pos{File: fileName, Line: 1, Column: 1})
 	var lastEvalued EvalResult
-	for _, sexpression := range sexpressions {
-		lastEvalued = eval(sexpression, env)
+	for {
+		sexpression, err := r.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return newEvalErrorResult(err)
+		}
+		if err := t.interruption(); err != nil {
+			return newEvalErrorResult(err)
+		}
+		// every top level form sees the definitions of the previous ones
+		formTask := t.withGlobals()
+		expanded, err := expandMacros(sexpression, formTask)
+		if err != nil {
+			return newEvalErrorResult(err)
+		}
+		lastEvalued = eval(expanded, env, formTask)
 		if lastEvalued.Err != nil {
 			return lastEvalued
 		}
@@ -338,19 +550,20 @@ func loadLambda(args Cell, env *environmentEntry) EvalResult {
 	return lastEvalued
 }
 
-func writeLambda(args Cell, env *environmentEntry) EvalResult {
-	phrases := extractCars(args)
-	if len(phrases) == 0 {
-		fmt.Println()
+func writeLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	if args == nil {
+		fmt.Fprintln(t.interp.config.Output)
 		return newEvalPositiveResult(makeString(""))
-	} else if len(phrases) > 1 {
-		return newEvalErrorResult(newEvalError("[write] write needs at least one string argument"))
 	}
-	fmt.Println((phrases[0].(*stringCell)).Str)
-	return newEvalPositiveResult(phrases[0])
+	phrase, isString := car(args).(*stringCell)
+	if !isString {
+		return newEvalErrorResult(newTypeError("write", car(args), "a string"))
+	}
+	fmt.Fprintln(t.interp.config.Output, phrase.Str)
+	return newEvalPositiveResult(phrase)
 }
 
-func listLambda(args Cell, env *environmentEntry) EvalResult {
+func listLambda(args Cell, env *environmentEntry, t *task) EvalResult {
 	var top Cell
 	var actLast Cell
 	var newVal Cell
@@ -363,7 +576,10 @@ func listLambda(args Cell, env *environmentEntry) EvalResult {
 	return newEvalPositiveResult(top)
 }
 
-func reverseLambda(args Cell, env *environmentEntry) EvalResult {
+func reverseLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	if !isList(car(args)) {
+		return newEvalErrorResult(newTypeError("reverse", car(args), "a list"))
+	}
 	var top Cell
 	act := car(args)
 	for act != nil {
@@ -373,8 +589,11 @@ func reverseLambda(args Cell, env *environmentEntry) EvalResult {
 	return newEvalPositiveResult(top)
 }
 
-func memberLambda(args Cell, env *environmentEntry) EvalResult {
+func memberLambda(args Cell, env *environmentEntry, t *task) EvalResult {
 	toFind := car(args)
+	if !isList(cadr(args)) {
+		return newEvalErrorResult(newTypeError("member", cadr(args), "a list"))
+	}
 	act := cadr(args)
 	for act != nil {
 		if eq(toFind, car(act)) {
@@ -385,62 +604,93 @@ func memberLambda(args Cell, env *environmentEntry) EvalResult {
 	return newEvalPositiveResult(nil)
 }
 
-func nthLambda(args Cell, env *environmentEntry) EvalResult {
-	n := (car(args).(*intCell)).Val
+func nthLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	index, isInt := car(args).(*intCell)
+	if !isInt || index.Val < 0 {
+		return newEvalErrorResult(newTypeError("nth", car(args), "a non-negative fixnum"))
+	}
+	if !isList(cadr(args)) {
+		return newEvalErrorResult(newTypeError("nth", cadr(args), "a list"))
+	}
+	n := index.Val
 	act := cadr(args)
-	for n > 0 {
+	for n > 0 && act != nil {
 		n--
 		act = cdr(act)
 	}
+	if act == nil {
+		return newEvalPositiveResult(nil)
+	}
 	return newEvalPositiveResult(car(act))
 }
 
-func lengthLambda(args Cell, env *environmentEntry) EvalResult {
+func lengthLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	if !isList(car(args)) {
+		return newEvalErrorResult(newTypeError("length", car(args), "a list"))
+	}
 	return newEvalPositiveResult(makeInt(listLengt(car(args))))
 }
 
-func setLambda(args Cell, env *environmentEntry) EvalResult {
-	id := car(args)
+func setLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	id, isSymbol := car(args).(*symbolCell)
+	if !isSymbol {
+		return newEvalErrorResult(newTypeError("set", car(args), "a symbol"))
+	}
 	val := cadr(args)
-	globalEnv[(id.(*symbolCell)).Sym] = val
+	t.define(id.Sym, val)
 	return newEvalPositiveResult(val)
 }
 
-func onePlusLambda(args Cell, env *environmentEntry) EvalResult {
-	num := car(args).(*intCell)
-	return newEvalPositiveResult(makeInt(num.Val + 1))
+func onePlusLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	if !isNumber(car(args)) {
+		return newEvalErrorResult(notANumber("1+", car(args)))
+	}
+	return newEvalPositiveResult(addition.apply(car(args), makeInt(1)))
+}
+func oneMinusLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	if !isNumber(car(args)) {
+		return newEvalErrorResult(notANumber("1-", car(args)))
+	}
+	return newEvalPositiveResult(subtraction.apply(car(args), makeInt(1)))
 }
-func oneMinusLambda(args Cell, env *environmentEntry) EvalResult {
-	num := car(args).(*intCell)
-	return newEvalPositiveResult(makeInt(num.Val - 1))
+
+func touchLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	return touch(car(args), t)
 }
 
-func integerpLambda(args Cell, env *environmentEntry) EvalResult {
+func integerpLambda(args Cell, env *environmentEntry, t *task) EvalResult {
 	switch car(args).(type) {
-	case *intCell:
-		return newEvalPositiveResult(lisp.getTrueSymbol())
+	case *intCell, *bigIntCell:
+		return newEvalPositiveResult(t.lang().getTrueSymbol())
 	default:
 		return newEvalPositiveResult(nil)
 	}
 }
 
-func symbolpLambda(args Cell, env *environmentEntry) EvalResult {
+func numberpLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	if isNumber(car(args)) {
+		return newEvalPositiveResult(t.lang().getTrueSymbol())
+	}
+	return newEvalPositiveResult(nil)
+}
+
+func symbolpLambda(args Cell, env *environmentEntry, t *task) EvalResult {
 	switch car(args).(type) {
 	case *builtinLambdaCell:
-		return newEvalPositiveResult(lisp.getTrueSymbol())
+		return newEvalPositiveResult(t.lang().getTrueSymbol())
 	case *builtinMacroCell:
-		return newEvalPositiveResult(lisp.getTrueSymbol())
+		return newEvalPositiveResult(t.lang().getTrueSymbol())
 	case *symbolCell:
-		return newEvalPositiveResult(lisp.getTrueSymbol())
+		return newEvalPositiveResult(t.lang().getTrueSymbol())
 	default:
 		return newEvalPositiveResult(nil)
 	}
 }
 
-func unimplementedMacro(c Cell, env *environmentEntry) EvalResult {
+func unimplementedMacro(c Cell, env *environmentEntry, t *task) EvalResult {
 	panic("unimplemented macro")
 }
 
-func unimplementedLambda(c Cell, env *environmentEntry) EvalResult {
+func unimplementedLambda(c Cell, env *environmentEntry, t *task) EvalResult {
 	panic("unimplemented lambda")
 }
@@ -2,7 +2,9 @@ package lisp
 
 import (
 	"fmt"
+	"math/big"
 	"strconv"
+	"strings"
 )
 
 /*******************************************************************************
@@ -26,6 +28,78 @@ func (i intCell) Eq(c Cell) bool {
 	}
 }
 
+/*******************************************************************************
+ Big int cell
+*******************************************************************************/
+
+// bigIntCell is one integer which does not fit in one intCell: the integers
+// which fit are always intCells
+type bigIntCell struct {
+	Val *big.Int
+}
+
+func (i bigIntCell) String() string {
+	return i.Val.String()
+}
+
+func (i bigIntCell) Eq(c Cell) bool {
+	switch castedC := c.(type) {
+	case *bigIntCell:
+		return castedC.Val.Cmp(i.Val) == 0
+	default:
+		return false
+	}
+}
+
+/*******************************************************************************
+ Rational cell
+*******************************************************************************/
+
+// ratCell is one exact fraction, never with denominator 1: the integers are
+// always intCells or bigIntCells
+type ratCell struct {
+	Val *big.Rat
+}
+
+func (r ratCell) String() string {
+	return r.Val.RatString()
+}
+
+func (r ratCell) Eq(c Cell) bool {
+	switch castedC := c.(type) {
+	case *ratCell:
+		return castedC.Val.Cmp(r.Val) == 0
+	default:
+		return false
+	}
+}
+
+/*******************************************************************************
+ Float cell
+*******************************************************************************/
+
+type floatCell struct {
+	Val float64
+}
+
+// String prints the float so that it reads back as one float
+func (f floatCell) String() string {
+	s := strconv.FormatFloat(f.Val, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+
+func (f floatCell) Eq(c Cell) bool {
+	switch castedC := c.(type) {
+	case *floatCell:
+		return castedC.Val == f.Val
+	default:
+		return false
+	}
+}
+
 /*******************************************************************************
  String cell
 *******************************************************************************/
@@ -35,7 +109,7 @@ type stringCell struct {
 }
 
 func (s stringCell) String() string {
-	return ("\"" + s.Str + "\"")
+	return escapeString(s.Str)
 }
 
 func (s stringCell) Eq(c Cell) bool {
@@ -72,9 +146,17 @@ func (s symbolCell) Eq(c Cell) bool {
  Builtin lambda cell
 *******************************************************************************/
 
+// variadic is the MaxArgs of the builtins with no maximum number of arguments
+const variadic = -1
+
 type builtinLambdaCell struct {
 	Sym    string
-	Lambda func(Cell, *environmentEntry) EvalResult
+	Lambda func(Cell, *environmentEntry, *task) EvalResult
+	// MinArgs and MaxArgs are checked before calling Lambda
+	MinArgs int
+	MaxArgs int
+	// NonStrict builtins receive the futures in their arguments untouched
+	NonStrict bool
 }
 
 func (l builtinLambdaCell) String() string {
@@ -96,12 +178,19 @@ func (l builtinLambdaCell) Eq(c Cell) bool {
 
 type builtinMacroCell struct {
 	Sym   string
-	Macro func(Cell, *environmentEntry) EvalResult
+	Macro func(Cell, *environmentEntry, *task) EvalResult
+	// MinArgs and MaxArgs are checked before expanding the macro
+	MinArgs int
+	MaxArgs int
+	// TailMacro replaces Macro for the macros with one expression in tail
+	// position: it returns the expression, with the environment and the task
+	// to evaluate it in, instead of evaluating it
+	TailMacro func(Cell, *environmentEntry, *task) (tailCall, error)
 }
 
 func (m builtinMacroCell) String() string {
-	if m.Sym == "quote" {
-		return "'"
+	if prefix, isQuote := quotePrefixes[m.Sym]; isQuote {
+		return prefix
 	}
 	if m.Sym == "lambda" {
 		return "λ"
@@ -118,6 +207,90 @@ func (m builtinMacroCell) Eq(c Cell) bool {
 	}
 }
 
+/*******************************************************************************
+ Closure cell
+*******************************************************************************/
+
+// closureCell is one lambda together with the environment it was defined in
+type closureCell struct {
+	Params Cell
+	Body   Cell
+	Env    *environmentEntry
+}
+
+func (l closureCell) String() string {
+	return fmt.Sprintf("%v", makeCons(&builtinMacroCell{Sym: "lambda"}, makeCons(l.Params, makeCons(l.Body, nil))))
+}
+
+func (l *closureCell) Eq(c Cell) bool {
+	return l == c
+}
+
+// quotePrefixes are the forms printed like they are read, by their prefix
+var quotePrefixes = map[string]string{
+	"quote":            "'",
+	"quasiquote":       "`",
+	"unquote":          ",",
+	"unquote-splicing": ",@",
+}
+
+func isQuotePrefix(s string) bool {
+	switch s {
+	case "'", "`", ",", ",@":
+		return true
+	default:
+		return false
+	}
+}
+
+/*******************************************************************************
+ Macro cell
+*******************************************************************************/
+
+// macroCell is one macro defined with defmacro: it is applied to the forms of
+// its arguments, and returns the form to evaluate in their place
+type macroCell struct {
+	Sym    string
+	Params Cell
+	Body   Cell
+	// MinArgs and MaxArgs are checked before expanding the macro, MaxArgs is
+	// variadic if the last parameter follows &rest
+	MinArgs int
+	MaxArgs int
+}
+
+func (m macroCell) String() string {
+	return fmt.Sprintf("%v", makeCons(&symbolCell{Sym: "macro"}, makeCons(m.Params, makeCons(m.Body, nil))))
+}
+
+func (m *macroCell) Eq(c Cell) bool {
+	return m == c
+}
+
+/*******************************************************************************
+ Future cell
+*******************************************************************************/
+
+// futureCell is the placeholder for one value being computed in parallel
+type futureCell struct {
+	done   chan struct{}
+	result EvalResult
+	// strand is the ended strand of the future, if measuring the work and the span
+	strand *strand
+}
+
+func (f *futureCell) String() string {
+	res := f.touch()
+	if res.Err != nil {
+		return "#<future: " + res.Err.Error() + ">"
+	}
+	return fmt.Sprintf("%v", res.Cell)
+}
+
+func (f *futureCell) Eq(c Cell) bool {
+	return eq(f.touch().Cell, c)
+}
+
 /*******************************************************************************
  Cons cell
 *******************************************************************************/
@@ -125,8 +298,11 @@ func (m builtinMacroCell) Eq(c Cell) bool {
 type consCell struct {
 	Car      Cell
 	Cdr      Cell
-	Evlis    func(args Cell, env *environmentEntry) EvalResult
+	Evlis    func(form *consCell, env *environmentEntry, t *task) EvalResult
 	Parallel bool
+	// Span is the source of the list starting with the cell, nil if it was
+	// not parsed
+	Span *Span
 }
 
 func (c consCell) String() string {
@@ -143,8 +319,8 @@ func (c consCell) String() string {
 			act = nil
 		}
 	}
-	if left == "'" {
-		return "'" + rest[1:] // skip first char
+	if isQuotePrefix(left) && rest != "" {
+		return left + rest[1:] // skip first char
 	}
 	if c.Parallel {
 		return "{" + left + rest + "}"
@@ -4,32 +4,19 @@ func isClosure(formalParameters, actualParameters Cell) bool {
 	return listLengt(formalParameters) > listLengt(actualParameters)
 }
 
-func buildClosure(lambdaBody, formalParameters, actualParameters Cell) Cell {
+// buildClosure partially applies the lambda: the parameters matched by the
+// actual ones are bound in the environment of the new lambda
+func buildClosure(lambda *closureCell, actualParameters Cell) Cell {
 	// ((lambda (x y) (+ x y)) 1)
-	// head
-	top := makeCons(makeSymbol("lambda"), nil)
-	act := top
-
-	// unmatched parameters
-	actFormal := formalParameters
+	actFormal := lambda.Params
 	actActual := actualParameters
-	found := false
-	closureEnv := emptyEnv()
+	closureEnv := lambda.Env
 
-	for actFormal != nil && !found {
-		if actActual == nil {
-			// found
-			found = true
-		} else {
-			closureEnv = newEnvironmentEntry((car(actFormal)).(*symbolCell), car(actActual), closureEnv)
-			actFormal = cdr(actFormal)
-			actActual = cdr(actActual)
-		}
+	for actActual != nil {
+		closureEnv = newEnvironmentEntry((car(actFormal)).(*symbolCell), car(actActual), closureEnv)
+		actFormal = cdr(actFormal)
+		actActual = cdr(actActual)
 	}
-	appendCellToArgs(&top, &act, &actFormal)
-
-	closedBody := copyAndSubstituteSymbols(lambdaBody, closureEnv)
-	appendCellToArgs(&top, &act, &closedBody)
 
-	return top
+	return makeClosure(actFormal, lambda.Body, closureEnv)
 }
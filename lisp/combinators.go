@@ -0,0 +1,223 @@
+package lisp
+
+func mapcarLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	return mapList("mapcar", args, env, t, false)
+}
+
+func pmapLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	return mapList("pmap", args, env, t, true)
+}
+
+func filterLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	return filterList("filter", args, env, t, false)
+}
+
+func pfilterLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	return filterList("pfilter", args, env, t, true)
+}
+
+func reduceLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	return reduceList("reduce", args, env, t, false)
+}
+
+// preduceLambda combines the elements in any grouping: the function must be
+// associative
+func preduceLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	return reduceList("preduce", args, env, t, true)
+}
+
+// mapList applies the function to every element of the list
+func mapList(name string, args Cell, env *environmentEntry, t *task, parallel bool) EvalResult {
+	function := car(args)
+	elements, err := listToSlice(name, cadr(args))
+	if err != nil {
+		return newEvalErrorResult(err)
+	}
+	results := make([]Cell, len(elements))
+	chunks := chunksCount(len(elements), t, parallel)
+	err = forEachChunk(name, chunks, len(elements), t, func(chunk, from, to int, t *task) error {
+		for i := from; i < to; i++ {
+			res := apply(function, makeCons(elements[i], nil), env, t)
+			if res.Err != nil {
+				return res.Err
+			}
+			results[i] = res.Cell
+		}
+		return nil
+	})
+	if err != nil {
+		return newEvalErrorResult(err)
+	}
+	return newEvalPositiveResult(sliceToList(results))
+}
+
+// filterList keeps the elements of the list which satisfy the predicate
+func filterList(name string, args Cell, env *environmentEntry, t *task, parallel bool) EvalResult {
+	predicate := car(args)
+	elements, err := listToSlice(name, cadr(args))
+	if err != nil {
+		return newEvalErrorResult(err)
+	}
+	keep := make([]bool, len(elements))
+	chunks := chunksCount(len(elements), t, parallel)
+	err = forEachChunk(name, chunks, len(elements), t, func(chunk, from, to int, t *task) error {
+		for i := from; i < to; i++ {
+			res := apply(predicate, makeCons(elements[i], nil), env, t)
+			if res.Err == nil {
+				res = touch(res.Cell, t)
+			}
+			if res.Err != nil {
+				return res.Err
+			}
+			keep[i] = res.Cell != nil
+		}
+		return nil
+	})
+	if err != nil {
+		return newEvalErrorResult(err)
+	}
+	var kept []Cell
+	for i := range elements {
+		if keep[i] {
+			kept = append(kept, elements[i])
+		}
+	}
+	return newEvalPositiveResult(sliceToList(kept))
+}
+
+// reduceList combines the elements of the list from left to right, starting
+// from the initial value if there is one. In parallel every chunk is reduced
+// on its own, then the partial results are combined in order
+func reduceList(name string, args Cell, env *environmentEntry, t *task, parallel bool) EvalResult {
+	function := car(args)
+	elements, err := listToSlice(name, cadr(args))
+	if err != nil {
+		return newEvalErrorResult(err)
+	}
+	if cddr(args) != nil {
+		elements = append([]Cell{caddr(args)}, elements...)
+	}
+	if len(elements) == 0 {
+		return newEvalErrorResult(newEvalError("[" + name + "] empty list and no initial value"))
+	}
+	combine := func(values []Cell, t *task) (Cell, error) {
+		acc := values[0]
+		for _, value := range values[1:] {
+			res := apply(function, makeCons(acc, makeCons(value, nil)), env, t)
+			if res.Err != nil {
+				return nil, res.Err
+			}
+			acc = res.Cell
+		}
+		return acc, nil
+	}
+	chunks := chunksCount(len(elements), t, parallel)
+	partials := make([]Cell, chunks)
+	err = forEachChunk(name, chunks, len(elements), t, func(chunk, from, to int, t *task) error {
+		partial, err := combine(elements[from:to], t)
+		partials[chunk] = partial
+		return err
+	})
+	if err != nil {
+		return newEvalErrorResult(err)
+	}
+	result, err := combine(partials, t)
+	if err != nil {
+		return newEvalErrorResult(err)
+	}
+	return newEvalPositiveResult(result)
+}
+
+// chunksCount returns in how many chunks n elements are split: one per worker
+// in parallel, but never more than the elements. In sequential mode there is
+// only one chunk
+func chunksCount(n int, t *task, parallel bool) int {
+	chunks := 1
+	if parallel && !t.interp.IsSequential() {
+		chunks = t.interp.config.Workers
+	}
+	if chunks > n {
+		chunks = n
+	}
+	return chunks
+}
+
+// forEachChunk splits n elements in chunks of the same size and calls do on
+// each of them. The chunks are run by the idle workers, or inline when there
+// are none, each one in a child task: the first chunk that fails cancels the
+// ones after it, and forEachChunk returns without waiting for them. The error
+// returned is the one of the first failing chunk, with the chunk of the
+// function name in its trace
+func forEachChunk(name string, chunks, n int, t *task, do func(chunk, from, to int, t *task) error) error {
+	if chunks <= 1 {
+		if n == 0 {
+			return nil
+		}
+		return do(0, 0, n, t)
+	}
+	var errs branchErrors
+	defer errs.release()
+	strands := t.forkStrands()
+	finished := make(chan int, chunks)
+	started := 0
+	for i := 0; i < chunks && !errs.failed(); i++ {
+		chunk, from, to := i, i*n/chunks, (i+1)*n/chunks
+		chunkTask := strands.branch(errs.fork(chunk, t))
+		started++
+		job := func(worker int) {
+			defer func() { finished <- chunk }()
+			err := func() (err error) {
+				defer recoverPanic(&err)
+				return do(chunk, from, to, chunkTask.onWorker(worker))
+			}()
+			if err != nil {
+				errs.fail(chunk, err)
+			}
+			strands.done(chunkTask)
+		}
+		if chunk == chunks-1 || !t.trySpawn(job) {
+			job(t.worker)
+		}
+	}
+	// the chunks are started in order: wait for them until the first which
+	// is after one failure
+	received := make([]bool, chunks)
+	for waited := 0; waited < started && !errs.failedBefore(waited); {
+		received[<-finished] = true
+		for waited < started && received[waited] {
+			waited++
+		}
+	}
+	strands.join()
+	if errs.failed() {
+		err, chunk := errs.first(t)
+		if chunk < 0 {
+			return err
+		}
+		return withFrame(err, Frame{Function: name, Branch: chunk + 1})
+	}
+	return nil
+}
+
+// listToSlice returns the elements of one list, or an error if it is not a list
+func listToSlice(name string, lst Cell) ([]Cell, error) {
+	var elements []Cell
+	for act := lst; act != nil; {
+		cons, isCons := act.(*consCell)
+		if !isCons {
+			return nil, newTypeError(name, lst, "a list")
+		}
+		elements = append(elements, cons.Car)
+		act = cons.Cdr
+	}
+	return elements, nil
+}
+
+func sliceToList(elements []Cell) Cell {
+	var top Cell
+	var actCons Cell
+	for i := range elements {
+		appendCellToArgs(&top, &actCons, &elements[i])
+	}
+	return top
+}
@@ -3,6 +3,8 @@ package lisp
 import (
 	"fmt"
 	"runtime"
+	"sync"
+	"sync/atomic"
 )
 
 func emptyEnv() *environmentEntry {
@@ -23,20 +25,6 @@ type environmentEntry struct {
 	Next *environmentEntry
 }
 
-func symbolIsInEnv(c *symbolCell, env *environmentEntry) bool {
-	if env == nil {
-		return false
-	}
-	act := env
-	for act != nil {
-		if (act.Pair.Symbol.Sym) == c.Sym {
-			return true
-		}
-		act = act.Next
-	}
-	return false
-}
-
 type environmentPair struct {
 	Symbol *symbolCell
 	Value  Cell
@@ -49,23 +37,107 @@ func (e *environmentEntry) String() string {
 	return fmt.Sprintf("%v -> %v\n", e.Pair.Symbol, e.Pair.Value) + fmt.Sprintf("%v", e.Next)
 }
 
-var globalEnv = make(map[string]Cell)
+// globalEnvironment holds the definitions of one interpreter. It is safe for
+// concurrent use: the readers never lock, they look into one immutable
+// snapshot, while every definition copies the last snapshot and publishes the
+// new one
+type globalEnvironment struct {
+	writersMutex sync.Mutex
+	current      atomic.Value // *globalSnapshot
+}
+
+// globalSnapshot is the state of the global environment at one point in
+// time, it must not be modified
+type globalSnapshot struct {
+	values map[string]Cell
+	// specials are the symbols declared with defvar or defparameter
+	specials map[string]bool
+}
+
+func newGlobalEnvironment() *globalEnvironment {
+	g := new(globalEnvironment)
+	g.current.Store(&globalSnapshot{
+		values:   make(map[string]Cell),
+		specials: make(map[string]bool),
+	})
+	return g
+}
+
+func (g *globalEnvironment) snapshot() *globalSnapshot {
+	return g.current.Load().(*globalSnapshot)
+}
+
+// update publishes the snapshot obtained modifying one copy of the last one
+func (g *globalEnvironment) update(modify func(values map[string]Cell, specials map[string]bool)) *globalSnapshot {
+	g.writersMutex.Lock()
+	defer g.writersMutex.Unlock()
+	last := g.snapshot()
+	next := &globalSnapshot{
+		values:   make(map[string]Cell, len(last.values)+1),
+		specials: make(map[string]bool, len(last.specials)),
+	}
+	for sym, value := range last.values {
+		next.values[sym] = value
+	}
+	for sym := range last.specials {
+		next.specials[sym] = true
+	}
+	modify(next.values, next.specials)
+	g.current.Store(next)
+	return next
+}
+
+// define binds the value to the symbol and returns the new snapshot
+func (g *globalEnvironment) define(sym string, value Cell) *globalSnapshot {
+	return g.update(func(values map[string]Cell, specials map[string]bool) {
+		values[sym] = value
+	})
+}
+
+// declareSpecial marks the symbol as special. If hasValue is set the value is
+// bound to the symbol, unless it is already bound and overwrite is not set
+func (g *globalEnvironment) declareSpecial(sym string, value Cell, hasValue, overwrite bool) *globalSnapshot {
+	return g.update(func(values map[string]Cell, specials map[string]bool) {
+		specials[sym] = true
+		if _, isBound := values[sym]; hasValue && (overwrite || !isBound) {
+			values[sym] = value
+		}
+	})
+}
+
+func (s *globalSnapshot) lookup(sym string) (Cell, bool) {
+	value, isBound := s.values[sym]
+	return value, isBound
+}
 
-func initGlobalEnv() {
+// isSpecialSymbol tells if the symbol was declared with defvar or defparameter
+func (s *globalSnapshot) isSpecialSymbol(sym *symbolCell) bool {
+	return len(s.specials) > 0 && s.specials[sym.Sym]
+}
+
+func (interp *Interpreter) initGlobalEnv() {
 	// necessary
-	globalEnv["id"], _ = Parse("(lambda (x) x)")
-	globalEnv["t"], _ = Parse("t")
-	globalEnv["null"], _ = Parse("(lambda (x) (eq x nil))")
-	globalEnv["ncpu"], _ = Parse(fmt.Sprintf("%v", runtime.NumCPU()))
+	interp.globalEnv.define("id", interp.evalSource("(lambda (x) x)"))
+	trueSymbol, _ := interp.Parse("t")
+	interp.globalEnv.define("t", trueSymbol)
+	interp.globalEnv.define("null", interp.evalSource("(lambda (x) (eq x nil))"))
+	ncpu, _ := interp.Parse(fmt.Sprintf("%v", runtime.NumCPU()))
+	interp.globalEnv.define("ncpu", ncpu)
+
+	interp.globalEnv.define("take", interp.evalSource("(lambda (lst n) (cond ((eq n 0) nil) (t (cons (car lst) (take (cdr lst) (1- n))))))"))
+	interp.globalEnv.define("drop", interp.evalSource("(lambda (lst n) (cond ((eq n 0) lst) (t (drop (cdr lst) (1- n)))))"))
+	interp.globalEnv.define("first-half", interp.evalSource("(lambda (lst) (take lst (floor (length lst) 2)))"))
+	interp.globalEnv.define("second-half", interp.evalSource("(lambda (lst) (drop lst (floor (length lst) 2)))"))
 
-	globalEnv["take"], _ = Parse("(lambda (lst n) (cond ((eq n 0) nil) (t (cons (car lst) (take (cdr lst) (1- n))))))")
-	globalEnv["drop"], _ = Parse("(lambda (lst n) (cond ((eq n 0) lst) (t (drop (cdr lst) (1- n)))))")
-	globalEnv["first-half"], _ = Parse("(lambda (lst) (take lst (/ (length lst) 2)))")
-	globalEnv["second-half"], _ = Parse("(lambda (lst) (drop lst (/ (length lst) 2)))")
+	interp.globalEnv.define("parallelize", interp.evalSource("(lambda (sequential-algorithm is-base-case split-left split-right combinator  generic-data) (parallelize-ric  1 sequential-algorithm is-base-case split-left split-right combinator  generic-data))"))
+	interp.globalEnv.define("parallelize-ric", interp.evalSource("(lambda (partitions sequential-algorithm is-base-case split-left split-right combinator generic-data) (cond ((is-base-case generic-data) (sequential-algorithm generic-data)) ((< partitions ncpu) (let ((new-partitions (* partitions 2))) {combinator (parallelize-ric new-partitions sequential-algorithm is-base-case split-right split-left combinator (split-left generic-data)) (parallelize-ric new-partitions sequential-algorithm is-base-case split-right split-left combinator (split-right generic-data)) })) (t (combinator (sequential-algorithm (split-left generic-data)) (sequential-algorithm (split-right generic-data)) ))))"))
 
-	globalEnv["parallelize"], _ = Parse("(lambda (sequential-algorithm is-base-case split-left split-right combinator  generic-data) (parallelize-ric  1 sequential-algorithm is-base-case split-left split-right combinator  generic-data))")
-	globalEnv["parallelize-ric"], _ = Parse("(lambda (partitions sequential-algorithm is-base-case split-left split-right combinator generic-data) (cond ((is-base-case generic-data) (sequential-algorithm generic-data)) ((< partitions ncpu) (let ((new-partitions (* partitions 2))) {combinator (parallelize-ric new-partitions sequential-algorithm is-base-case split-right split-left combinator (split-left generic-data)) (parallelize-ric new-partitions sequential-algorithm is-base-case split-right split-left combinator (split-right generic-data)) })) (t (combinator (sequential-algorithm (split-left generic-data)) (sequential-algorithm (split-right generic-data)) ))))")
+	interp.globalEnv.define("divide-et-impera", interp.evalSource("(lambda (sequential-algorithm combinator lst) (divide-et-impera-ric 1 sequential-algorithm combinator lst))"))
+	interp.globalEnv.define("divide-et-impera-ric", interp.evalSource("(lambda (partitions sequential-algorithm combinator lst) (cond ((eq lst nil)        (sequential-algorithm lst)) ((eq (length lst) 1) (sequential-algorithm lst)) ((< partitions ncpu) (let ((new-partitions (* partitions 2))) {combinator (divide-et-impera-ric new-partitions sequential-algorithm combinator (first-half  lst)) (divide-et-impera-ric new-partitions sequential-algorithm combinator (second-half lst)) })) (t (combinator (sequential-algorithm (first-half  lst)) (sequential-algorithm (second-half lst)) ))))"))
+}
 
-	globalEnv["divide-et-impera"], _ = Parse("(lambda (sequential-algorithm combinator lst) (divide-et-impera-ric 1 sequential-algorithm combinator lst))")
-	globalEnv["divide-et-impera-ric"], _ = Parse("(lambda (partitions sequential-algorithm combinator lst) (cond ((eq lst nil)        (sequential-algorithm lst)) ((eq (length lst) 1) (sequential-algorithm lst)) ((< partitions ncpu) (let ((new-partitions (* partitions 2))) {combinator (divide-et-impera-ric new-partitions sequential-algorithm combinator (first-half  lst)) (divide-et-impera-ric new-partitions sequential-algorithm combinator (second-half lst)) })) (t (combinator (sequential-algorithm (first-half  lst)) (sequential-algorithm (second-half lst)) ))))")
+// evalSource evaluates the lisp source of one definition of the global environment
+func (interp *Interpreter) evalSource(source string) Cell {
+	sexpression, _ := interp.Parse(source)
+	return interp.Eval(sexpression).Cell
 }
@@ -1,71 +1,193 @@
 package lisp
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
 
-func eval(toEval Cell, env *environmentEntry) EvalResult {
-	if toEval == nil {
-		return newEvalPositiveResult(nil)
+// eval runs in a loop the expressions in tail position (cond branches, let
+// bodies and lambda bodies), so that tail recursion takes constant stack space
+func eval(toEval Cell, env *environmentEntry, t *task) EvalResult {
+	// environment of the call site of the lambda whose body is being evaluated,
+	// only used to explain the errors due to lexical scoping
+	var callerEnv *environmentEntry
+	// function whose body is being evaluated and its call site, only used
+	// in the traces of the errors
+	var callee Cell
+	var callSite *Span
+	// span of the application whose body is being evaluated
+	var span traceSpan
+	if t.tracer != nil {
+		defer func() { span.end() }()
 	}
-	switch c := toEval.(type) {
-	case *intCell:
-		return newEvalPositiveResult(c)
-	case *stringCell:
-		return newEvalPositiveResult(c)
-	case *symbolCell:
-		return assoc(c, env)
-	case *consCell:
-		switch car := c.Car.(type) {
+	for {
+		if toEval == nil {
+			return newEvalPositiveResult(nil)
+		}
+		if err := t.interruption(); err != nil {
+			return newEvalErrorResult(err)
+		}
+		var result EvalResult
+		switch c := toEval.(type) {
+		case *intCell, *bigIntCell, *ratCell, *floatCell:
+			return newEvalPositiveResult(c)
+		case *stringCell:
+			return newEvalPositiveResult(c)
+		case *symbolCell:
+			result = assoc(c, env, t)
+		case *consCell:
+			switch car := c.Car.(type) {
+			case *builtinMacroCell:
+				if err := checkArity(car.Sym, car.MinArgs, car.MaxArgs, c.Cdr); err != nil {
+					result = newEvalErrorResult(err)
+					break
+				}
+				if car.TailMacro == nil {
+					result = car.Macro(c.Cdr, env, t)
+					break
+				}
+				next, err := car.TailMacro(c.Cdr, env, t)
+				if err != nil {
+					result = newEvalErrorResult(err)
+					break
+				}
+				toEval, env, t = next.expr, next.env, next.task
+				continue
+			default:
+				if !isList(c.Cdr) {
+					result = newEvalErrorResult(newTypeError("eval", c, "a proper list"))
+					break
+				}
+				argsResult := c.Evlis(c, env, t)
+				if argsResult.Err != nil {
+					result = newEvalErrorResult(argsResult.Err)
+					break
+				}
+				next, applyResult, isTailCall := applyTail(car, argsResult.Cell, env, t)
+				if !isTailCall {
+					result = applyResult
+					break
+				}
+				callerEnv, callee, callSite = env, car, c.Span
+				if t.tracer != nil {
+					span.end()
+					span = t.tracer.begin(functionName(car), "apply", t.worker)
+				}
+				toEval, env, t = next.expr, next.env, next.task
+				continue
+			}
+			if result.Err != nil && c.Span != nil {
+				result.Err = withPos(result.Err, c.Span.Start)
+			}
+		// builtin symbols autoquote: allows higer order functions
 		case *builtinMacroCell:
-			return car.Macro(c.Cdr, env)
+			return newEvalPositiveResult(c)
+		case *builtinLambdaCell:
+			return newEvalPositiveResult(c)
+		case *closureCell:
+			return newEvalPositiveResult(c)
+		case *futureCell:
+			return newEvalPositiveResult(c)
 		default:
-			argsResult := c.Evlis(c.Cdr, env)
-			if argsResult.Err != nil {
-				return newEvalErrorResult(argsResult.Err)
-			}
-			return apply(car, argsResult.Cell, env)
+			return newEvalErrorResult(newEvalError("[eval] Unknown cell type: " + fmt.Sprintf("%v", toEval)))
 		}
-	// builtin symbols autoquote: allows higer order functions
-	case *builtinMacroCell:
-		return newEvalPositiveResult(c)
-	case *builtinLambdaCell:
-		return newEvalPositiveResult(c)
-	default:
-		return newEvalErrorResult(newEvalError("[eval] Unknown cell type: " + fmt.Sprintf("%v", toEval)))
+		if result.Err != nil && callerEnv != nil {
+			result.Err = explainUnboundSymbol(result.Err, callerEnv)
+		}
+		if result.Err != nil && callee != nil {
+			result.Err = withFrame(result.Err, Frame{Function: functionName(callee), Pos: spanStart(callSite)})
+		}
+		return result
 	}
 }
 
-func evlisParallel(args Cell, env *environmentEntry) EvalResult {
+// tailCall is one expression in tail position, with the environment and the
+// task to evaluate it in
+type tailCall struct {
+	expr Cell
+	env  *environmentEntry
+	task *task
+}
+
+// evlisArgs evaluates the arguments of one () form
+func evlisArgs(form *consCell, env *environmentEntry, t *task) EvalResult {
+	return evlisSequential(form.Cdr, env, t)
+}
+
+// evlisParallelArgs evaluates the arguments of one {} form
+func evlisParallelArgs(form *consCell, env *environmentEntry, t *task) EvalResult {
+	return evlisParallel("{}", form.Cdr, env, t, t.profiler.site(form))
+}
+
+// evlisParallel is evlisSequential when the interpreter is in sequential mode.
+// name is the parallel form, in the trace of the errors. stats is nil unless
+// profiling
+func evlisParallel(name string, args Cell, env *environmentEntry, t *task, stats *siteStats) EvalResult {
+	if t.interp.IsSequential() && t.strand == nil {
+		return evlisSequential(args, env, t)
+	}
+	stats.evaluated()
 	n := listLengt(args)
 
 	if n == 0 {
 		return newEvalPositiveResult(nil)
 	}
 
-	// send eval requests
+	// send eval requests to the idle workers, evaluate inline when there are none.
+	// Once one argument is spawned, the next ones run in child tasks: when
+	// one of them fails it cancels the arguments after it, whose value is
+	// not needed anymore
 	evaluedArgsChan := make(chan evalArgumentResult, n)
+	valuedArgs := make([]Cell, n)
+	var spawned []int
+	var errs branchErrors
+	defer errs.release()
+	strands := t.forkStrands()
 	act := args
-	i := 0
-	for act != nil && cdr(act) != nil {
-		go evalArgumentWithChan(car(act), env, i, evaluedArgsChan)
+	for i := 0; i < n && !errs.failed(); i++ {
+		argument, argIndex, isLast := car(act), i, i == n-1
 		act = cdr(act)
-		i++
+		argTask := t
+		if len(spawned) > 0 || (!isLast && t.hasIdleWorkers()) {
+			argTask = errs.fork(argIndex, t)
+		}
+		if !isLast && argTask != t && t.trySpawn(func(worker int) {
+			evalArgumentWithChan(argument, env, argIndex, argTask.onWorker(worker), &errs, stats, evaluedArgsChan)
+		}) {
+			spawned = append(spawned, argIndex)
+			continue
+		}
+		argTask = strands.branch(argTask)
+		span := argTask.tracer.beginBranch(argument, argTask.worker)
+		inlineResult := eval(argument, env, argTask)
+		span.end()
+		strands.done(argTask)
+		if inlineResult.Err != nil {
+			errs.fail(argIndex, inlineResult.Err)
+		}
+		valuedArgs[argIndex] = inlineResult.Cell
 	}
 
-	// eval last arg
-	lastArgResult := eval(car(act), env)
-	if lastArgResult.Err != nil {
-		return lastArgResult
-	}
+	strands.join()
 
-	// receive args
-	valuedArgs := make([]Cell, n-1)
-	var evaluedArg evalArgumentResult
-	for i := 0; i < n-1; i++ {
-		evaluedArg = <-evaluedArgsChan
-		if evaluedArg.res.Err != nil {
-			return newEvalErrorResult(evaluedArg.res.Err)
-		}
+	// receive args, but not the ones after one failure: they are cancelled
+	// and their result can not change the error anymore
+	waitStart := stats.waitStarted()
+	received := make([]bool, n)
+	for len(spawned) > 0 && !errs.failedBefore(spawned[0]) {
+		evaluedArg := <-evaluedArgsChan
 		valuedArgs[evaluedArg.argIndex] = evaluedArg.res.Cell
+		received[evaluedArg.argIndex] = true
+		for len(spawned) > 0 && received[spawned[0]] {
+			spawned = spawned[1:]
+		}
+	}
+	stats.waitFinished(waitStart)
+	if errs.failed() {
+		err, branch := errs.first(t)
+		return newEvalErrorResult(withBranchFrame(err, name, args, branch))
 	}
 
 	// append in order
@@ -74,28 +196,193 @@ func evlisParallel(args Cell, env *environmentEntry) EvalResult {
 	for i := range valuedArgs {
 		appendCellToArgs(&top, &actCons, &(valuedArgs[i]))
 	}
-	appendCellToArgs(&top, &actCons, &lastArgResult.Cell)
 
 	return newEvalPositiveResult(top)
 }
 
+// speculate evaluates the arguments in parallel and returns the first value
+// for which decides is true, as soon as it is found, cancelling the arguments
+// still running. If no value decides it returns the last one. The first error
+// stops the evaluation as in {} forms
+func speculate(name string, args Cell, env *environmentEntry, t *task, decides func(Cell) bool) EvalResult {
+	n := listLengt(args)
+	branchesTask, cancel := t.fork()
+	defer cancel()
+	outcome := speculationOutcome{cancel: cancel}
+	strands := t.forkStrands()
+	var wg sync.WaitGroup
+	var last Cell
+	i := 0
+	for act := args; act != nil && !outcome.isDecided(); act = cdr(act) {
+		argument, argIndex, isLast := car(act), i, i == n-1
+		evalArgument := func(worker int) {
+			branchTask := strands.branch(branchesTask.branch().onWorker(worker))
+			res := safeEval(argument, env, branchTask)
+			if res.Err == nil {
+				res = touch(res.Cell, branchTask)
+			}
+			strands.done(branchTask)
+			if res.Err != nil || decides(res.Cell) {
+				outcome.decide(res, argIndex)
+			} else if isLast {
+				last = res.Cell
+			}
+		}
+		wg.Add(1)
+		job := func(worker int) {
+			defer wg.Done()
+			evalArgument(worker)
+		}
+		if isLast || !t.trySpawn(job) {
+			job(t.worker)
+		}
+		i++
+	}
+	wg.Wait()
+	strands.join()
+	if outcome.isDecided() {
+		if outcome.res.Err != nil {
+			return newEvalErrorResult(withBranchFrame(outcome.res.Err, name, args, outcome.branch))
+		}
+		return outcome.res
+	}
+	return newEvalPositiveResult(last)
+}
+
+// speculationOutcome records the first argument of one por or pand which
+// decides the result, and cancels the others
+type speculationOutcome struct {
+	once    sync.Once
+	decided int32
+	res     EvalResult
+	branch  int
+	cancel  context.CancelFunc
+}
+
+func (o *speculationOutcome) decide(res EvalResult, branch int) {
+	o.once.Do(func() {
+		o.res, o.branch = res, branch
+		atomic.StoreInt32(&o.decided, 1)
+		o.cancel()
+	})
+}
+
+func (o *speculationOutcome) isDecided() bool {
+	return atomic.LoadInt32(&o.decided) == 1
+}
+
 type evalArgumentResult struct {
 	res      EvalResult
 	argIndex int
 }
 
-func evalArgumentWithChan(argument Cell, env *environmentEntry, argIndex int, replyChan chan<- evalArgumentResult) {
-	replyChan <- evalArgumentResult{eval(argument, env), argIndex}
+func evalArgumentWithChan(argument Cell, env *environmentEntry, argIndex int, t *task, errs *branchErrors, stats *siteStats, replyChan chan<- evalArgumentResult) {
+	start := stats.branchStarted()
+	span := t.tracer.beginBranch(argument, t.worker)
+	res := safeEval(argument, env, t)
+	span.end()
+	stats.branchFinished(start)
+	if res.Err != nil {
+		// stop the siblings right away, without waiting for the parent to receive
+		errs.fail(argIndex, res.Err)
+	}
+	replyChan <- evalArgumentResult{res, argIndex}
+}
+
+// branchErrors collects the errors of the parallel branches of one form.
+// When one branch fails the branches after it are cancelled, while the ones
+// before it go on: the error reported is the one of the first failing branch
+// in argument order, as if they were evaluated sequentially
+type branchErrors struct {
+	mutex    sync.Mutex
+	errs     map[int]error
+	branches []forkedBranch
+}
+
+type forkedBranch struct {
+	index  int
+	cancel context.CancelFunc
+}
+
+// fork returns the task of one branch
+func (b *branchErrors) fork(branch int, t *task) *task {
+	child, cancel := t.fork()
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.branches = append(b.branches, forkedBranch{branch, cancel})
+	return child
+}
+
+func (b *branchErrors) fail(branch int, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.errs == nil {
+		b.errs = make(map[int]error)
+	}
+	b.errs[branch] = err
+	for _, forked := range b.branches {
+		if forked.index > branch {
+			forked.cancel()
+		}
+	}
+}
+
+func (b *branchErrors) failed() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.errs != nil
+}
+
+// failedBefore tells if one branch before branch failed, so that its result
+// is not needed
+func (b *branchErrors) failedBefore(branch int) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for failed := range b.errs {
+		if failed < branch {
+			return true
+		}
+	}
+	return false
+}
+
+// first returns the error to report once the branches before the failing
+// ones returned, with its branch: the one which interrupted t if any, with
+// branch -1
+func (b *branchErrors) first(t *task) (error, int) {
+	if err := t.interruption(); err != nil {
+		return err, -1
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	var first error
+	firstBranch := -1
+	for branch, err := range b.errs {
+		if first == nil || branch < firstBranch {
+			first, firstBranch = err, branch
+		}
+	}
+	return first, firstBranch
+}
+
+// release cancels the tasks of all the branches, it must be called when the
+// form returns
+func (b *branchErrors) release() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for _, forked := range b.branches {
+		forked.cancel()
+	}
 }
 
-func evlisSequential(args Cell, env *environmentEntry) EvalResult {
+func evlisSequential(args Cell, env *environmentEntry, t *task) EvalResult {
 	actArg := args
 	var top Cell
 	var actCons Cell
 	var evaluedArg EvalResult
 
 	for actArg != nil {
-		evaluedArg = eval(actArg.(*consCell).Car, env)
+		evaluedArg = eval(actArg.(*consCell).Car, env, t)
 		if evaluedArg.Err != nil {
 			return evaluedArg
 		}
@@ -105,46 +392,83 @@ func evlisSequential(args Cell, env *environmentEntry) EvalResult {
 	return newEvalResult(top, nil)
 }
 
-func apply(function Cell, args Cell, env *environmentEntry) EvalResult {
-	switch functionCasted := function.(type) {
-	case *builtinLambdaCell:
-		return functionCasted.Lambda(args, env)
-	case *consCell:
-		if lisp.isLambdaSymbol(functionCasted.Car) {
-			formalParameters := cadr(function)
-			lambdaBody := caddr(function)
-			if isClosure(formalParameters, args) {
-				return newEvalPositiveResult(buildClosure(lambdaBody, formalParameters, args))
+func apply(function Cell, args Cell, env *environmentEntry, t *task) EvalResult {
+	next, result, isTailCall := applyTail(function, args, env, t)
+	if isTailCall {
+		span := t.tracer.begin(functionName(function), "apply", t.worker)
+		result = eval(next.expr, next.env, next.task)
+		span.end()
+		if result.Err != nil {
+			result.Err = withFrame(result.Err, Frame{Function: functionName(function)})
+		}
+	}
+	return result
+}
+
+// applyTail does not evaluate the body of the lambdas: it returns it, together
+// with the environment extending the one where the lambda was defined, and
+// isTailCall set. Otherwise it returns the result of the application
+func applyTail(function Cell, args Cell, env *environmentEntry, t *task) (next tailCall, result EvalResult, isTailCall bool) {
+	for {
+		if err := t.interruption(); err != nil {
+			return next, newEvalErrorResult(err), false
+		}
+		switch functionCasted := function.(type) {
+		case *builtinLambdaCell:
+			if err := checkArity(functionCasted.Sym, functionCasted.MinArgs, functionCasted.MaxArgs, args); err != nil {
+				return next, newEvalErrorResult(err), false
+			}
+			if !functionCasted.NonStrict {
+				touchedArgs, err := touchArgs(args, t)
+				if err != nil {
+					return next, newEvalErrorResult(err), false
+				}
+				args = touchedArgs
 			}
-			newEnv, err := pairlis(formalParameters, args, env)
+			return next, functionCasted.Lambda(args, env, t), false
+		case *futureCell:
+			touchedFunction := touch(functionCasted, t)
+			if touchedFunction.Err != nil {
+				return next, touchedFunction, false
+			}
+			function = touchedFunction.Cell
+		case *closureCell:
+			if isClosure(functionCasted.Params, args) {
+				return next, newEvalPositiveResult(buildClosure(functionCasted, args)), false
+			}
+			newEnv, newTask, err := pairlis(functionCasted.Params, args, functionCasted.Env, t)
 			if err != nil {
-				return newEvalErrorResult(err)
+				return next, newEvalErrorResult(err), false
 			}
-			return eval(lambdaBody, newEnv)
-		}
-		// partial apply
-		partiallyAppliedFunction := eval(function, env)
-		if partiallyAppliedFunction.Err != nil {
-			return partiallyAppliedFunction
-		}
-		return apply(partiallyAppliedFunction.Cell, args, env)
-	case *symbolCell:
-		evaluedFunction := eval(function, env)
-		if evaluedFunction.Err != nil {
-			return newEvalErrorResult(evaluedFunction.Err)
+			return tailCall{functionCasted.Body, newEnv, newTask}, result, true
+		case *consCell:
+			// lambda expression or partial apply
+			evaluedFunction := eval(function, env, t)
+			if evaluedFunction.Err != nil {
+				return next, evaluedFunction, false
+			}
+			function = evaluedFunction.Cell
+		case *symbolCell:
+			evaluedFunction := eval(function, env, t)
+			if evaluedFunction.Err != nil {
+				return next, newEvalErrorResult(evaluedFunction.Err), false
+			}
+			if _, isSymbol := evaluedFunction.Cell.(*symbolCell); isSymbol {
+				return next, newEvalErrorResult(newEvalError("[apply] " + functionCasted.Sym + " is not a function")), false
+			}
+			function = evaluedFunction.Cell
+		default:
+			return next, newEvalErrorResult(newEvalError("[apply] trying to apply non-builtin, non-lambda, non-symbol")), false
 		}
-		return apply(evaluedFunction.Cell, args, env)
-	default:
-		return newEvalErrorResult(newEvalError("[apply] trying to apply non-builtin, non-lambda, non-symbol"))
 	}
 }
 
-func assoc(symbol *symbolCell, env *environmentEntry) EvalResult {
-	if res, isInglobalEnv := globalEnv[symbol.Sym]; isInglobalEnv {
-		return newEvalPositiveResult(res)
-	}
-	if env == nil {
-		return newEvalErrorResult(newEvalError("[assoc] symbol " + symbol.Sym + " not in env"))
+// assoc looks for the symbol in the lexical environment and then in the global
+// one. The special symbols are looked for in the dynamic environment of the
+// task instead of the lexical one
+func assoc(symbol *symbolCell, env *environmentEntry, t *task) EvalResult {
+	if t.globals.isSpecialSymbol(symbol) {
+		env = t.dynamic
 	}
 	act := env
 	for act != nil {
@@ -153,22 +477,109 @@ func assoc(symbol *symbolCell, env *environmentEntry) EvalResult {
 		}
 		act = act.Next
 	}
-	return newEvalErrorResult(newEvalError("[assoc] symbol " + symbol.Sym + " not in env"))
+	if res, isInglobalEnv := t.globals.lookup(symbol.Sym); isInglobalEnv {
+		return newEvalPositiveResult(res)
+	}
+	err := newEvalError("[assoc] symbol " + symbol.Sym + " not in env")
+	err.unboundSymbol = symbol.Sym
+	return newEvalErrorResult(err)
 }
 
-func pairlis(formalParameters, actualParameters Cell, oldEnv *environmentEntry) (*environmentEntry, error) {
+// pairlis binds the formal parameters to the actual ones. The special symbols
+// are bound in the dynamic environment of the returned task
+func pairlis(formalParameters, actualParameters Cell, oldEnv *environmentEntry, t *task) (*environmentEntry, *task, error) {
 	actFormal := formalParameters
 	actActual := actualParameters
 	newEntry := oldEnv
+	newDynamic := t.dynamic
 	for actFormal != nil {
 		if actActual == nil {
-			return nil, newEvalError("[parilis] not enough actual parameters")
+			return nil, nil, newEvalError("[parilis] not enough actual parameters")
+		}
+		sym, isSymbol := car(actFormal).(*symbolCell)
+		if !isSymbol {
+			return nil, nil, newTypeError("pairlis", car(actFormal), "a symbol")
+		}
+		if t.globals.isSpecialSymbol(sym) {
+			newDynamic = newEnvironmentEntry(sym, car(actActual), newDynamic)
+		} else {
+			newEntry = newEnvironmentEntry(sym, car(actActual), newEntry)
 		}
-		newEntry = newEnvironmentEntry((car(actFormal)).(*symbolCell), car(actActual), newEntry)
 		actFormal = (actFormal.(*consCell)).Cdr
 		actActual = (actActual.(*consCell)).Cdr
 	}
-	return newEntry, nil
+	return newEntry, t.withDynamic(newDynamic), nil
+}
+
+// explainUnboundSymbol marks the errors due to one symbol which is not bound
+// in the environment of the lambda, but that would have been found in the
+// environment of its caller with dynamic scoping
+func explainUnboundSymbol(err error, callerEnv *environmentEntry) error {
+	evalErr, isEvalError := err.(EvalError)
+	if !isEvalError || evalErr.unboundSymbol == "" || evalErr.boundByCaller {
+		return err
+	}
+	for act := callerEnv; act != nil; act = act.Next {
+		if act.Pair.Symbol.Sym == evalErr.unboundSymbol {
+			evalErr.boundByCaller = true
+			return evalErr
+		}
+	}
+	return err
+}
+
+// withPos sets the position of the error, if it is one EvalError without it
+func withPos(err error, pos Pos) error {
+	evalErr, isEvalError := err.(EvalError)
+	if !isEvalError || evalErr.Pos.IsValid() {
+		return err
+	}
+	evalErr.Pos = pos
+	return evalErr
+}
+
+// traceFrame is one frame of the trace of one EvalError, linked to the frame
+// inside it. The frames are never modified, so that many errors can share
+// the inner ones
+type traceFrame struct {
+	Frame
+	inner *traceFrame
+}
+
+// withFrame adds the outer frame to the trace of the error, if it is one
+// EvalError
+func withFrame(err error, frame Frame) error {
+	evalErr, isEvalError := err.(EvalError)
+	if !isEvalError {
+		return err
+	}
+	evalErr.frames = &traceFrame{frame, evalErr.frames}
+	return evalErr
+}
+
+// withBranchFrame marks the error as coming from the argument of index branch
+// of the parallel form, nothing if branch is negative
+func withBranchFrame(err error, form string, args Cell, branch int) error {
+	if branch < 0 {
+		return err
+	}
+	argument := args
+	for i := 0; i < branch; i++ {
+		argument = cdr(argument)
+	}
+	var pos Pos
+	if cons, isCons := car(argument).(*consCell); isCons {
+		pos = spanStart(cons.Span)
+	}
+	return withFrame(err, Frame{Function: form, Branch: branch + 1, Pos: pos})
+}
+
+// spanStart returns the start of the span, the unknown position if it is nil
+func spanStart(span *Span) Pos {
+	if span == nil {
+		return Pos{}
+	}
+	return span.Start
 }
 
 func newEvalError(e string) EvalError {
@@ -178,6 +589,70 @@ func newEvalError(e string) EvalError {
 	return r
 }
 
+func newTypeError(name string, c Cell, expected string) EvalError {
+	return EvalError{
+		Err:  "[" + name + "] " + fmt.Sprintf("%v", c) + " is not " + expected,
+		Kind: TypeError,
+	}
+}
+
+func newArityError(name string, message string) EvalError {
+	return EvalError{
+		Err:  "[" + name + "] " + message,
+		Kind: ArityError,
+	}
+}
+
+// checkArity returns one ArityError if the builtin does not accept the number
+// of the arguments, one TypeError if they are not a list. maxArgs is variadic
+// if there is no upper bound
+func checkArity(name string, minArgs, maxArgs int, args Cell) error {
+	n := 0
+	act := args
+	for ; act != nil; act = cdr(act) {
+		if _, isCons := act.(*consCell); !isCons {
+			return newTypeError(name, args, "a list of arguments")
+		}
+		n++
+	}
+	switch {
+	case n >= minArgs && (n <= maxArgs || maxArgs == variadic):
+		return nil
+	case minArgs == maxArgs:
+		return newArityError(name, fmt.Sprintf("%v needs %v, got %v", name, arguments(minArgs), n))
+	case maxArgs == variadic:
+		return newArityError(name, fmt.Sprintf("%v needs at least %v, got %v", name, arguments(minArgs), n))
+	default:
+		return newArityError(name, fmt.Sprintf("%v needs from %v to %v arguments, got %v", name, minArgs, maxArgs, n))
+	}
+}
+
+func arguments(n int) string {
+	if n == 1 {
+		return "1 argument"
+	}
+	return fmt.Sprintf("%v arguments", n)
+}
+
+// recoverPanic turns one panic of the evaluation into one InternalError, it
+// must be deferred. The goroutines which evaluate must recover, since nothing
+// else can
+func recoverPanic(err *error) {
+	if recovered := recover(); recovered != nil {
+		*err = EvalError{
+			Err:  fmt.Sprintf("[eval] internal error: %v", recovered),
+			Kind: InternalError,
+		}
+	}
+}
+
+// safeEval is eval for the goroutines of the workers and for the public
+// interface: the panics become errors
+func safeEval(c Cell, env *environmentEntry, t *task) (result EvalResult) {
+	defer recoverPanic(&result.Err)
+	return eval(c, env, t)
+}
+
 func newEvalResult(c Cell, e error) EvalResult {
 	r := EvalResult{
 		Cell: c,
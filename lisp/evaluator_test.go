@@ -0,0 +1,161 @@
+package lisp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// one failing branch cancels the branches after it, even if they never end
+func TestFailingBranchCancelsTheNextOnes(t *testing.T) {
+	interp := NewInterpreter(Config{Workers: 2})
+	defer interp.Close()
+	evalSource(t, interp, "(defun inf (x) (inf x))")
+	for _, source := range []string{
+		"{+ (car 5) (inf 0)}",
+		"{+ (car 5) (inf 0) (inf 0) 1}",
+		"(plet ((a (car 5)) (b (inf 0))) a)",
+		"(pmap (lambda (x) (cond ((eq x 0) (car 5)) (t (inf x)))) '(0 1))",
+		"(pfilter (lambda (x) (cond ((eq x 0) (car 5)) (t (inf x)))) '(0 1))",
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		result := interp.EvalContext(ctx, parseSource(t, interp, source))
+		cancel()
+		if result.Err == nil || !strings.Contains(result.Err.Error(), "[car] 5") {
+			t.Errorf("%v: got %v, want the error of (car 5)", source, result)
+		}
+	}
+}
+
+// the error reported is the one of the first failing branch in argument
+// order, as in the sequential evaluation, even if it fails last
+func TestFirstErrorInArgumentOrder(t *testing.T) {
+	interp := NewInterpreter(Config{Workers: 2})
+	defer interp.Close()
+	evalSource(t, interp, "(defun slowfail (n) (cond ((eq n 0) (car 1)) (t (slowfail (- n 1)))))")
+	for _, source := range []string{
+		"{+ (slowfail 2000) (car 2)}",
+		"{+ (car 1) (car 2)}",
+		"(plet ((a (slowfail 2000)) (b (car 2))) a)",
+		"(pmap (lambda (x) (cond ((eq x 0) (slowfail 2000)) (t (car 2)))) '(0 1))",
+	} {
+		sexpression := parseSource(t, interp, source)
+		for run := 0; run < 20; run++ {
+			result := interp.Eval(sexpression)
+			if result.Err == nil || !strings.Contains(result.Err.Error(), "[car] 1") {
+				t.Fatalf("%v, run %v: got %v, want the error of (car 1)", source, run, result)
+			}
+		}
+	}
+}
+
+func parseSource(t *testing.T, interp *Interpreter, source string) Cell {
+	t.Helper()
+	sexpression, err := interp.Parse(source)
+	if err != nil {
+		t.Fatalf("%v: %v", source, err)
+	}
+	return sexpression
+}
+
+func evalSource(t *testing.T, interp *Interpreter, source string) Cell {
+	t.Helper()
+	result := interp.Eval(parseSource(t, interp, source))
+	if result.Err != nil {
+		t.Fatalf("%v: %v", source, result.Err)
+	}
+	return result.Cell
+}
+
+// the arguments of one call must be a proper list
+func TestDottedCallForm(t *testing.T) {
+	interp := NewInterpreter(Config{Workers: 2})
+	defer interp.Close()
+	for _, source := range []string{"(+ . 5)", "(+ 1 . 2)", "{+ 1 . 2}", "{+ . 2}", "(quote . 1)"} {
+		result := interp.Eval(parseSource(t, interp, source))
+		evalErr, isEvalError := result.Err.(EvalError)
+		if !isEvalError || evalErr.Kind != TypeError {
+			t.Errorf("%v: got %v, want one TypeError", source, result)
+			continue
+		}
+		if evalErr.Pos != (Pos{Line: 1, Column: 1}) {
+			t.Errorf("%v: got the error at %v, want 1:1", source, evalErr.Pos)
+		}
+	}
+}
+
+// the errors of the parallel forms tell which of their branches failed
+func TestBranchFrames(t *testing.T) {
+	interp := NewInterpreter(Config{Workers: 2})
+	defer interp.Close()
+	for source, want := range map[string]string{
+		"{+ 1 (car 5)}":                                   "{} argument 2",
+		"(plet ((a 1) (b (car 5))) a)":                    "plet binding 2",
+		"(pmap (lambda (x) (car x)) '((1) (2) 3 (4)))":    "pmap chunk 2",
+		"(pfilter (lambda (x) (car x)) '((1) (2) 3 4))":   "pfilter chunk 2",
+		"(preduce (lambda (x y) (car y)) '(1 2 (3) (4)))": "preduce chunk 1",
+	} {
+		result := interp.Eval(parseSource(t, interp, source))
+		evalErr, isEvalError := result.Err.(EvalError)
+		if !isEvalError {
+			t.Errorf("%v: got %v, want one EvalError", source, result)
+			continue
+		}
+		var frames []string
+		for _, frame := range evalErr.Trace() {
+			frames = append(frames, frame.String())
+		}
+		if len(frames) == 0 || !strings.HasPrefix(frames[len(frames)-1], want) {
+			t.Errorf("%v: got the trace %v, want it to end with %v", source, frames, want)
+		}
+	}
+}
+
+// the branches of por and pand define the globals in their own task
+func TestSetInSpeculation(t *testing.T) {
+	interp := NewInterpreter(Config{Workers: 4})
+	defer interp.Close()
+	for _, source := range []string{
+		"(por (set 'a nil) (set 'b nil) (set 'c nil) (set 'd nil))",
+		"(pand (set 'a 1) (set 'b 2) (set 'c 3) (set 'd 4))",
+	} {
+		sexpression := parseSource(t, interp, source)
+		for run := 0; run < 20; run++ {
+			if result := interp.Eval(sexpression); result.Err != nil {
+				t.Fatalf("%v: %v", source, result.Err)
+			}
+		}
+	}
+}
+
+// the functions defined with defun capture the environment they are
+// defined in, like lambdas
+func TestDefunInLet(t *testing.T) {
+	interp := NewInterpreter(Config{Workers: 1})
+	defer interp.Close()
+	evalSource(t, interp, "(let ((k 10)) (defun add-k (x) (+ x k)))")
+	if result := evalSource(t, interp, "(add-k 5)"); !result.Eq(makeInt(15)) {
+		t.Errorf("got %v, want 15", result)
+	}
+}
+
+// the futures still running when their evaluation returns are cancelled,
+// the ones in the result are done
+func TestFuturesEndWithTheEvaluation(t *testing.T) {
+	interp := NewInterpreter(Config{Workers: 1})
+	defer interp.Close()
+	evalSource(t, interp, "(defun inf (x) (inf x))")
+	evalSource(t, interp, "(car (list 1 (future (inf 0))))")
+	for start := time.Now(); !interp.sched.hasIdleWorkers(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatal("the future is still running")
+		}
+	}
+
+	evalSource(t, interp, "(defun produce (n) (cond ((eq n 0) nil) (t (cons n (future (produce (- n 1)))))))")
+	if produced := fmt.Sprintf("%v", evalSource(t, interp, "(produce 5)")); strings.Contains(produced, "#<future") {
+		t.Errorf("got %v, want the values of all the futures", produced)
+	}
+}
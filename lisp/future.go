@@ -0,0 +1,87 @@
+package lisp
+
+// spawnFuture starts the evaluation of the expression on one idle worker and
+// returns its placeholder right away. When no worker is idle, or in
+// sequential mode, the expression is evaluated before returning
+func spawnFuture(expr Cell, env *environmentEntry, t *task) *futureCell {
+	f := &futureCell{done: make(chan struct{})}
+	futureTask := t.detach()
+	if t.strand != nil {
+		// the future is one strand which forks here and joins when touched
+		t.strand.pause()
+		futureTask = futureTask.withStrand(t.strand.child())
+		defer t.strand.resume()
+	}
+	compute := func(worker int) {
+		f.result = safeEval(expr, env, futureTask.onWorker(worker))
+		if futureTask.strand != nil {
+			futureTask.strand.pause()
+			f.strand = futureTask.strand
+		}
+		close(f.done)
+	}
+	if !t.trySpawn(compute) {
+		compute(t.worker)
+	}
+	return f
+}
+
+// touch waits for the value of the future
+func (f *futureCell) touch() EvalResult {
+	<-f.done
+	if next, isFuture := f.result.Cell.(*futureCell); isFuture && f.result.Err == nil {
+		return next.touch()
+	}
+	return f.result
+}
+
+// touch returns the value of c, waiting for it if c is a future
+func touch(c Cell, t *task) EvalResult {
+	f, isFuture := c.(*futureCell)
+	if !isFuture {
+		return newEvalPositiveResult(c)
+	}
+	res := f.touch()
+	if f.strand != nil && t.strand != nil {
+		t.strand.join(f.strand)
+	}
+	return res
+}
+
+// waitFutures waits for the futures in the lists of c, at any depth. Their
+// errors stay in the futures
+func waitFutures(c Cell) {
+	for {
+		if f, isFuture := c.(*futureCell); isFuture {
+			c = f.touch().Cell
+		}
+		cons, isCons := c.(*consCell)
+		if !isCons {
+			return
+		}
+		waitFutures(cons.Car)
+		c = cons.Cdr
+	}
+}
+
+// touchArgs returns the arguments with the futures replaced by their values.
+// The list is copied only if it contains futures
+func touchArgs(args Cell, t *task) (Cell, error) {
+	hasFutures := false
+	for act := args; act != nil && !hasFutures; act = cdr(act) {
+		_, hasFutures = car(act).(*futureCell)
+	}
+	if !hasFutures {
+		return args, nil
+	}
+	var top Cell
+	var actCons Cell
+	for act := args; act != nil; act = cdr(act) {
+		touched := touch(car(act), t)
+		if touched.Err != nil {
+			return nil, touched.Err
+		}
+		appendCellToArgs(&top, &actCons, &touched.Cell)
+	}
+	return top, nil
+}
@@ -1,11 +1,9 @@
 package lisp
 
-// lisp is the global variable for the language
-var lisp *language
-
-func initLanguage() {
-	lisp = newLanguage()
-}
+import (
+	"sync"
+	"sync/atomic"
+)
 
 type language struct {
 	// reading in concurrent maps: https://github.com/golang/go/issues/5179
@@ -13,6 +11,11 @@ type language struct {
 	builtinMacros         map[string]builtinMacroCell
 	builtinSpecialSymbols map[string]symbolCell
 	trueSymbol            symbolCell
+	// macros holds the macros defined with defmacro. As in the global
+	// environment the readers never lock: every definition copies the map
+	// and publishes the new one
+	macrosMutex sync.Mutex
+	macros      atomic.Value // map[string]*macroCell
 }
 
 func (lang *language) isBuiltinSymbol(s string) (bool, Cell) {
@@ -55,12 +58,38 @@ func (lang *language) isBuiltinSpecialSymbol(s string) (bool, Cell) {
 	return isBuiltinSpecialSymbol, &builtinSpecialSymbol
 }
 
-func (lang *language) hasSideEffect(c Cell) bool {
+// lookupMacro returns the macro defined with defmacro named s, if any
+func (lang *language) lookupMacro(s string) (*macroCell, bool) {
+	macro, isMacro := lang.macros.Load().(map[string]*macroCell)[s]
+	return macro, isMacro
+}
+
+// hasMacros tells if some macro was defined, otherwise there is nothing to
+// expand
+func (lang *language) hasMacros() bool {
+	return len(lang.macros.Load().(map[string]*macroCell)) > 0
+}
+
+// defineMacro adds the macro to the language, replacing the one with the same
+// name
+func (lang *language) defineMacro(macro *macroCell) {
+	lang.macrosMutex.Lock()
+	defer lang.macrosMutex.Unlock()
+	last := lang.macros.Load().(map[string]*macroCell)
+	next := make(map[string]*macroCell, len(last)+1)
+	for sym, m := range last {
+		next[sym] = m
+	}
+	next[macro.Sym] = macro
+	lang.macros.Store(next)
+}
+
+func hasSideEffect(c Cell) bool {
 	switch cell := c.(type) {
 	case *builtinLambdaCell:
 		return cell.Sym == "write" || cell.Sym == "load" || cell.Sym == "set"
 	case *builtinMacroCell:
-		return cell.Sym == "defun" || cell.Sym == "setq"
+		return cell.Sym == "defun" || cell.Sym == "setq" || cell.Sym == "defvar" || cell.Sym == "defparameter" || cell.Sym == "defmacro"
 	default:
 		return false
 	}
@@ -70,167 +99,406 @@ func (lang *language) getTrueSymbol() Cell {
 	return &(lang.trueSymbol)
 }
 
-func (lang *language) isLambdaSymbol(c Cell) bool {
-	switch sym := c.(type) {
-	case *builtinMacroCell:
-		return sym.Sym == "lambda"
-	default:
-		return false
-	}
-}
-
 func newLanguage() *language {
 	lisp := language{
 		builtinLambdas: map[string]builtinLambdaCell{
 
 			"car": builtinLambdaCell{
-				Sym:    "car",
-				Lambda: carLambda},
+				Sym:     "car",
+				Lambda:  carLambda,
+				MinArgs: 1,
+				MaxArgs: 1},
 
 			"cdr": builtinLambdaCell{
-				Sym:    "cdr",
-				Lambda: cdrLambda},
+				Sym:     "cdr",
+				Lambda:  cdrLambda,
+				MinArgs: 1,
+				MaxArgs: 1},
 
 			"cons": builtinLambdaCell{
-				Sym:    "cons",
-				Lambda: consLambda},
+				Sym:       "cons",
+				Lambda:    consLambda,
+				NonStrict: true,
+				MinArgs:   2,
+				MaxArgs:   2},
 
 			"eq": builtinLambdaCell{
-				Sym:    "eq",
-				Lambda: eqLambda},
+				Sym:     "eq",
+				Lambda:  eqLambda,
+				MinArgs: 2,
+				MaxArgs: 2},
 
 			"atom": builtinLambdaCell{
-				Sym:    "atom",
-				Lambda: atomLambda},
+				Sym:     "atom",
+				Lambda:  atomLambda,
+				MinArgs: 1,
+				MaxArgs: 1},
 
 			"+": builtinLambdaCell{
-				Sym:    "+",
-				Lambda: plusLambda},
+				Sym:     "+",
+				Lambda:  plusLambda,
+				MinArgs: 0,
+				MaxArgs: variadic},
 
 			"-": builtinLambdaCell{
-				Sym:    "-",
-				Lambda: minusLambda},
+				Sym:     "-",
+				Lambda:  minusLambda,
+				MinArgs: 1,
+				MaxArgs: variadic},
 
 			"*": builtinLambdaCell{
-				Sym:    "*",
-				Lambda: multLambda},
+				Sym:     "*",
+				Lambda:  multLambda,
+				MinArgs: 0,
+				MaxArgs: variadic},
 
 			"/": builtinLambdaCell{
-				Sym:    "/",
-				Lambda: divLambda},
+				Sym:     "/",
+				Lambda:  divLambda,
+				MinArgs: 1,
+				MaxArgs: variadic},
+
+			"floor": builtinLambdaCell{
+				Sym:     "floor",
+				Lambda:  floorLambda,
+				MinArgs: 1,
+				MaxArgs: 2},
+
+			"ceiling": builtinLambdaCell{
+				Sym:     "ceiling",
+				Lambda:  ceilingLambda,
+				MinArgs: 1,
+				MaxArgs: 2},
+
+			"round": builtinLambdaCell{
+				Sym:     "round",
+				Lambda:  roundLambda,
+				MinArgs: 1,
+				MaxArgs: 2},
+
+			"truncate": builtinLambdaCell{
+				Sym:     "truncate",
+				Lambda:  truncateLambda,
+				MinArgs: 1,
+				MaxArgs: 2},
+
+			"float": builtinLambdaCell{
+				Sym:     "float",
+				Lambda:  floatLambda,
+				MinArgs: 1,
+				MaxArgs: 1},
 
 			">": builtinLambdaCell{
-				Sym:    ">",
-				Lambda: greaterLambda},
+				Sym:     ">",
+				Lambda:  greaterLambda,
+				MinArgs: 1,
+				MaxArgs: variadic},
 
 			">=": builtinLambdaCell{
-				Sym:    ">=",
-				Lambda: greaterEqLambda},
+				Sym:     ">=",
+				Lambda:  greaterEqLambda,
+				MinArgs: 1,
+				MaxArgs: variadic},
 
 			"<": builtinLambdaCell{
-				Sym:    "<",
-				Lambda: lessLambda},
+				Sym:     "<",
+				Lambda:  lessLambda,
+				MinArgs: 1,
+				MaxArgs: variadic},
 
 			"<=": builtinLambdaCell{
-				Sym:    "<=",
-				Lambda: lessEqLambda},
+				Sym:     "<=",
+				Lambda:  lessEqLambda,
+				MinArgs: 1,
+				MaxArgs: variadic},
 
 			"or": builtinLambdaCell{
-				Sym:    "or",
-				Lambda: orLambda},
+				Sym:     "or",
+				Lambda:  orLambda,
+				MinArgs: 0,
+				MaxArgs: variadic},
 
 			"and": builtinLambdaCell{
-				Sym:    "and",
-				Lambda: andLambda},
+				Sym:     "and",
+				Lambda:  andLambda,
+				MinArgs: 0,
+				MaxArgs: variadic},
 
 			"not": builtinLambdaCell{
-				Sym:    "not",
-				Lambda: notLambda},
+				Sym:     "not",
+				Lambda:  notLambda,
+				MinArgs: 1,
+				MaxArgs: 1},
 
 			"list": builtinLambdaCell{
-				Sym:    "list",
-				Lambda: listLambda},
+				Sym:       "list",
+				Lambda:    listLambda,
+				NonStrict: true,
+				MinArgs:   0,
+				MaxArgs:   variadic},
 
 			"reverse": builtinLambdaCell{
-				Sym:    "reverse",
-				Lambda: reverseLambda},
+				Sym:     "reverse",
+				Lambda:  reverseLambda,
+				MinArgs: 1,
+				MaxArgs: 1},
 
 			"member": builtinLambdaCell{
-				Sym:    "member",
-				Lambda: memberLambda},
+				Sym:     "member",
+				Lambda:  memberLambda,
+				MinArgs: 2,
+				MaxArgs: 2},
 
 			"nth": builtinLambdaCell{
-				Sym:    "nth",
-				Lambda: nthLambda},
+				Sym:     "nth",
+				Lambda:  nthLambda,
+				MinArgs: 2,
+				MaxArgs: 2},
+
+			"mapcar": builtinLambdaCell{
+				Sym:     "mapcar",
+				Lambda:  mapcarLambda,
+				MinArgs: 2,
+				MaxArgs: 2},
+
+			"pmap": builtinLambdaCell{
+				Sym:     "pmap",
+				Lambda:  pmapLambda,
+				MinArgs: 2,
+				MaxArgs: 2},
+
+			"filter": builtinLambdaCell{
+				Sym:     "filter",
+				Lambda:  filterLambda,
+				MinArgs: 2,
+				MaxArgs: 2},
+
+			"pfilter": builtinLambdaCell{
+				Sym:     "pfilter",
+				Lambda:  pfilterLambda,
+				MinArgs: 2,
+				MaxArgs: 2},
+
+			"reduce": builtinLambdaCell{
+				Sym:     "reduce",
+				Lambda:  reduceLambda,
+				MinArgs: 2,
+				MaxArgs: 3},
+
+			"preduce": builtinLambdaCell{
+				Sym:     "preduce",
+				Lambda:  preduceLambda,
+				MinArgs: 2,
+				MaxArgs: 3},
 
 			"length": builtinLambdaCell{
-				Sym:    "length",
-				Lambda: lengthLambda},
+				Sym:     "length",
+				Lambda:  lengthLambda,
+				MinArgs: 1,
+				MaxArgs: 1},
 
 			"set": builtinLambdaCell{
-				Sym:    "set",
-				Lambda: setLambda},
+				Sym:     "set",
+				Lambda:  setLambda,
+				MinArgs: 2,
+				MaxArgs: 2},
 
 			"load": builtinLambdaCell{
-				Sym:    "load",
-				Lambda: loadLambda},
+				Sym:     "load",
+				Lambda:  loadLambda,
+				MinArgs: 1,
+				MaxArgs: 1},
 
 			"write": builtinLambdaCell{
-				Sym:    "write",
-				Lambda: writeLambda},
+				Sym:     "write",
+				Lambda:  writeLambda,
+				MinArgs: 0,
+				MaxArgs: 1},
 
 			"integerp": builtinLambdaCell{
-				Sym:    "integerp",
-				Lambda: integerpLambda},
+				Sym:     "integerp",
+				Lambda:  integerpLambda,
+				MinArgs: 1,
+				MaxArgs: 1},
+
+			"numberp": builtinLambdaCell{
+				Sym:     "numberp",
+				Lambda:  numberpLambda,
+				MinArgs: 1,
+				MaxArgs: 1},
 
 			"symbolp": builtinLambdaCell{
-				Sym:    "symbolp",
-				Lambda: symbolpLambda},
+				Sym:     "symbolp",
+				Lambda:  symbolpLambda,
+				MinArgs: 1,
+				MaxArgs: 1},
 
 			"1+": builtinLambdaCell{
-				Sym:    "1+",
-				Lambda: onePlusLambda},
+				Sym:     "1+",
+				Lambda:  onePlusLambda,
+				MinArgs: 1,
+				MaxArgs: 1},
 
 			"1-": builtinLambdaCell{
-				Sym:    "1-",
-				Lambda: oneMinusLambda},
+				Sym:     "1-",
+				Lambda:  oneMinusLambda,
+				MinArgs: 1,
+				MaxArgs: 1},
+
+			"touch": builtinLambdaCell{
+				Sym:     "touch",
+				Lambda:  touchLambda,
+				MinArgs: 1,
+				MaxArgs: 1},
+
+			"macroexpand-1": builtinLambdaCell{
+				Sym:     "macroexpand-1",
+				Lambda:  macroexpand1Lambda,
+				MinArgs: 1,
+				MaxArgs: 1},
+
+			"macroexpand": builtinLambdaCell{
+				Sym:     "macroexpand",
+				Lambda:  macroexpandLambda,
+				MinArgs: 1,
+				MaxArgs: 1},
 
 			// "label",
 		},
 
 		builtinMacros: map[string]builtinMacroCell{
 
+			"por": builtinMacroCell{
+				Sym:     "por",
+				Macro:   porMacro,
+				MinArgs: 0,
+				MaxArgs: variadic},
+
+			"pand": builtinMacroCell{
+				Sym:     "pand",
+				Macro:   pandMacro,
+				MinArgs: 0,
+				MaxArgs: variadic},
+
 			"quote": builtinMacroCell{
-				Sym:   "quote",
-				Macro: quoteMacro},
+				Sym:     "quote",
+				Macro:   quoteMacro,
+				MinArgs: 1,
+				MaxArgs: 1},
+
+			"quasiquote": builtinMacroCell{
+				Sym:     "quasiquote",
+				Macro:   quasiquoteMacro,
+				MinArgs: 1,
+				MaxArgs: 1},
+
+			"unquote": builtinMacroCell{
+				Sym:     "unquote",
+				Macro:   unquoteMacro,
+				MinArgs: 1,
+				MaxArgs: 1},
+
+			"unquote-splicing": builtinMacroCell{
+				Sym:     "unquote-splicing",
+				Macro:   unquoteSplicingMacro,
+				MinArgs: 1,
+				MaxArgs: 1},
 
 			"time": builtinMacroCell{
-				Sym:   "time",
-				Macro: timeMacro},
+				Sym:     "time",
+				Macro:   timeMacro,
+				MinArgs: 1,
+				MaxArgs: 1},
 
 			"cond": builtinMacroCell{
-				Sym:   "cond",
-				Macro: condMacro},
+				Sym:       "cond",
+				TailMacro: condMacro,
+				MinArgs:   0,
+				MaxArgs:   variadic},
+
+			"profile": builtinMacroCell{
+				Sym:     "profile",
+				Macro:   profileMacro,
+				MinArgs: 1,
+				MaxArgs: 1},
+
+			"with-trace": builtinMacroCell{
+				Sym:     "with-trace",
+				Macro:   withTraceMacro,
+				MinArgs: 2,
+				MaxArgs: 2},
+
+			"work-span": builtinMacroCell{
+				Sym:     "work-span",
+				Macro:   workSpanMacro,
+				MinArgs: 1,
+				MaxArgs: 1},
+
+			"simulate": builtinMacroCell{
+				Sym:     "simulate",
+				Macro:   simulateMacro,
+				MinArgs: 1,
+				MaxArgs: 1},
+
+			"future": builtinMacroCell{
+				Sym:     "future",
+				Macro:   futureMacro,
+				MinArgs: 1,
+				MaxArgs: 1},
 
 			"lambda": builtinMacroCell{
-				Sym:   "lambda",
-				Macro: lambdaMacro},
+				Sym:     "lambda",
+				Macro:   lambdaMacro,
+				MinArgs: 2,
+				MaxArgs: 2},
 
 			"defun": builtinMacroCell{
-				Sym:   "defun",
-				Macro: defunMacro},
+				Sym:     "defun",
+				Macro:   defunMacro,
+				MinArgs: 3,
+				MaxArgs: 3},
+
+			"defmacro": builtinMacroCell{
+				Sym:     "defmacro",
+				Macro:   defmacroMacro,
+				MinArgs: 3,
+				MaxArgs: 3},
 
 			"setq": builtinMacroCell{
-				Sym:   "setq",
-				Macro: setqMacro},
+				Sym:     "setq",
+				Macro:   setqMacro,
+				MinArgs: 2,
+				MaxArgs: 2},
 
 			"let": builtinMacroCell{
-				Sym:   "let",
-				Macro: letMacro},
+				Sym:       "let",
+				TailMacro: letMacro,
+				MinArgs:   2,
+				MaxArgs:   2},
+
+			"plet": builtinMacroCell{
+				Sym:       "plet",
+				TailMacro: pletMacro,
+				MinArgs:   2,
+				MaxArgs:   2},
+
+			"defvar": builtinMacroCell{
+				Sym:     "defvar",
+				Macro:   defvarMacro,
+				MinArgs: 1,
+				MaxArgs: 2},
+
+			"defparameter": builtinMacroCell{
+				Sym:     "defparameter",
+				Macro:   defparameterMacro,
+				MinArgs: 2,
+				MaxArgs: 2},
 
 			"dotimes": builtinMacroCell{
-				Sym:   "dotimes",
-				Macro: dotimesMacro},
+				Sym:     "dotimes",
+				Macro:   dotimesMacro,
+				MinArgs: 2,
+				MaxArgs: 2},
 		},
 
 		builtinSpecialSymbols: map[string]symbolCell{
@@ -241,5 +509,6 @@ func newLanguage() *language {
 
 		trueSymbol: symbolCell{Sym: "t"},
 	}
+	lisp.macros.Store(make(map[string]*macroCell))
 	return &lisp
 }
@@ -16,6 +16,10 @@ func cadr(c Cell) Cell {
 	return car(cdr(c.(*consCell)))
 }
 
+func cddr(c Cell) Cell {
+	return cdr(cdr(c))
+}
+
 func cdar(c Cell) Cell {
 	return cdr(car(c.(*consCell)))
 }
@@ -37,6 +41,18 @@ func listLengt(c Cell) int {
 	return n
 }
 
+// isList tells if the cell is one proper list
+func isList(c Cell) bool {
+	for c != nil {
+		cons, isCons := c.(*consCell)
+		if !isCons {
+			return false
+		}
+		c = cons.Cdr
+	}
+	return true
+}
+
 func eq(c1, c2 Cell) bool {
 	if c1 == nil && c2 == nil {
 		return true
@@ -47,30 +63,6 @@ func eq(c1, c2 Cell) bool {
 	return c1.Eq(c2)
 }
 
-// copies the structure of the cell, substituting every symbol which is in the
-// environment with its value.
-func copyAndSubstituteSymbols(c Cell, env *environmentEntry) Cell {
-	switch cell := c.(type) {
-	case *intCell:
-		return cell
-	case *stringCell:
-		return cell
-	case *builtinLambdaCell:
-		return cell
-	case *builtinMacroCell:
-		return cell
-	case *symbolCell:
-		if symbolIsInEnv(cell, env) {
-			return assoc(cell, env).Cell
-		}
-		return cell
-	case *consCell:
-		return makeCons(copyAndSubstituteSymbols(cell.Car, env), copyAndSubstituteSymbols(cell.Cdr, env))
-	default:
-		return nil
-	}
-}
-
 func extractCars(args Cell) []Cell {
 	act := args
 	var argsArray []Cell
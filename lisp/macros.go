@@ -0,0 +1,419 @@
+package lisp
+
+// restParameter marks the parameter of one macro bound to the list of the
+// remaining arguments
+const restParameter = "&rest"
+
+// defmacroMacro defines one macro: (defmacro name (params) body). The body is
+// evaluated with the parameters bound to the forms of the arguments, and its
+// value is the form evaluated in place of the call
+func defmacroMacro(args Cell, env *environmentEntry, t *task) EvalResult {
+	argsSlice := extractCars(args)
+	name, isSymbol := argsSlice[0].(*symbolCell)
+	if !isSymbol {
+		return newEvalErrorResult(newTypeError("defmacro", argsSlice[0], "a symbol"))
+	}
+	params := argsSlice[1]
+	if err := checkParameters("defmacro", params); err != nil {
+		return newEvalErrorResult(err)
+	}
+	macro := &macroCell{Sym: name.Sym, Params: params, Body: argsSlice[2], MaxArgs: variadic}
+	for act := params; act != nil; act = cdr(act) {
+		if car(act).(*symbolCell).Sym != restParameter {
+			macro.MinArgs++
+			continue
+		}
+		if cdr(act) == nil || cddr(act) != nil {
+			return newEvalErrorResult(newTypeError("defmacro", params, "a list of parameters with one after "+restParameter))
+		}
+		break
+	}
+	if macro.MinArgs == listLengt(params) {
+		macro.MaxArgs = macro.MinArgs
+	}
+	t.lang().defineMacro(macro)
+	return newEvalPositiveResult(macro)
+}
+
+// expand applies the macro to the forms of the arguments
+func (m *macroCell) expand(args Cell, t *task) EvalResult {
+	if err := checkArity(m.Sym, m.MinArgs, m.MaxArgs, args); err != nil {
+		return newEvalErrorResult(err)
+	}
+	// the arguments after &rest are bound as one list
+	var params, actuals Cell
+	var lastParam, lastActual Cell
+	actArg := args
+	for act := m.Params; act != nil; act = cdr(act) {
+		param, actual := car(act), car(actArg)
+		if param.(*symbolCell).Sym == restParameter {
+			param, actual = cadr(act), actArg
+			appendCellToArgs(&params, &lastParam, &param)
+			appendCellToArgs(&actuals, &lastActual, &actual)
+			break
+		}
+		appendCellToArgs(&params, &lastParam, &param)
+		appendCellToArgs(&actuals, &lastActual, &actual)
+		actArg = cdr(actArg)
+	}
+	macroEnv, macroTask, err := pairlis(params, actuals, emptyEnv(), t)
+	if err != nil {
+		return newEvalErrorResult(err)
+	}
+	result := eval(m.Body, macroEnv, macroTask)
+	if result.Err != nil {
+		return result
+	}
+	return touch(result.Cell, t)
+}
+
+// macroCall returns the macro called by the form, if it is one call of a
+// macro defined with defmacro
+func macroCall(form Cell, t *task) (*macroCell, *consCell, bool) {
+	cons, isCons := form.(*consCell)
+	if !isCons {
+		return nil, nil, false
+	}
+	sym, isSymbol := cons.Car.(*symbolCell)
+	if !isSymbol {
+		return nil, nil, false
+	}
+	macro, isMacro := t.lang().lookupMacro(sym.Sym)
+	return macro, cons, isMacro
+}
+
+// macroexpand1 expands the form once if it is one call of a macro, expanded
+// is false otherwise. The expansion starts where the call was in the source
+func macroexpand1(form Cell, t *task) (result Cell, expanded bool, err error) {
+	macro, call, isCall := macroCall(form, t)
+	if !isCall {
+		return form, false, nil
+	}
+	expansion := macro.expand(call.Cdr, t)
+	if expansion.Err != nil {
+		err := withPos(expansion.Err, spanStart(call.Span))
+		return nil, false, withFrame(err, Frame{Function: macro.Sym, Pos: spanStart(call.Span)})
+	}
+	if cons, isCons := expansion.Cell.(*consCell); isCons && cons.Span == nil && call.Span != nil {
+		// copied, the expansion may be shared with other calls
+		positioned := *cons
+		positioned.Span = call.Span
+		return &positioned, true, nil
+	}
+	return expansion.Cell, true, nil
+}
+
+// macroexpand expands the form until it is not one call of a macro
+func macroexpand(form Cell, t *task) (Cell, error) {
+	for {
+		expansion, expanded, err := macroexpand1(form, t)
+		if err != nil || !expanded {
+			return expansion, err
+		}
+		form = expansion
+	}
+}
+
+func macroexpand1Lambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	expansion, _, err := macroexpand1(car(args), t)
+	if err != nil {
+		return newEvalErrorResult(err)
+	}
+	return newEvalPositiveResult(expansion)
+}
+
+func macroexpandLambda(args Cell, env *environmentEntry, t *task) EvalResult {
+	expansion, err := macroexpand(car(args), t)
+	if err != nil {
+		return newEvalErrorResult(err)
+	}
+	return newEvalPositiveResult(expansion)
+}
+
+// expandMacros expands every call of the macros defined with defmacro in the
+// sexpression, before it is evaluated. The quoted forms, the parameters and
+// the names bound by the special forms are not expanded
+func expandMacros(c Cell, t *task) (expanded Cell, err error) {
+	if !t.lang().hasMacros() {
+		return c, nil
+	}
+	defer recoverPanic(&err)
+	return expandForm(c, t)
+}
+
+func expandForm(c Cell, t *task) (Cell, error) {
+	c, err := macroexpand(c, t)
+	if err != nil {
+		return nil, err
+	}
+	cons, isCons := c.(*consCell)
+	if !isCons {
+		return c, nil
+	}
+	expand := func(i int, form Cell) (Cell, error) {
+		return expandForm(form, t)
+	}
+	// skipping returns one function which expands the elements from the
+	// index from on
+	skipping := func(from int, expand func(int, Cell) (Cell, error)) func(int, Cell) (Cell, error) {
+		return func(i int, form Cell) (Cell, error) {
+			if i < from {
+				return form, nil
+			}
+			return expand(i, form)
+		}
+	}
+	macro, isBuiltinMacro := cons.Car.(*builtinMacroCell)
+	if !isBuiltinMacro {
+		return mapForms(cons, expand)
+	}
+	switch macro.Sym {
+	case "quote":
+		return cons, nil
+	case "quasiquote":
+		return mapForms(cons, skipping(1, func(i int, template Cell) (Cell, error) {
+			return expandTemplate(template, 1, t)
+		}))
+	case "lambda":
+		return mapForms(cons, skipping(2, expand))
+	case "defun", "defmacro":
+		return mapForms(cons, skipping(3, expand))
+	case "cond":
+		// every clause is one list of forms
+		return mapForms(cons, skipping(1, func(i int, clause Cell) (Cell, error) {
+			return mapForms(clause, expand)
+		}))
+	case "let", "plet":
+		// ((name value) ...) body
+		return mapForms(cons, func(i int, form Cell) (Cell, error) {
+			switch i {
+			case 0:
+				return form, nil
+			case 1:
+				return mapForms(form, func(i int, binding Cell) (Cell, error) {
+					return mapForms(binding, skipping(1, expand))
+				})
+			default:
+				return expandForm(form, t)
+			}
+		})
+	case "dotimes":
+		// (name count) body
+		return mapForms(cons, func(i int, form Cell) (Cell, error) {
+			switch i {
+			case 0:
+				return form, nil
+			case 1:
+				return mapForms(form, skipping(1, expand))
+			default:
+				return expandForm(form, t)
+			}
+		})
+	default:
+		return mapForms(cons, skipping(1, expand))
+	}
+}
+
+// expandTemplate expands the macros in the forms unquoted in the template of
+// one quasiquote, at the nesting level depth
+func expandTemplate(c Cell, depth int, t *task) (Cell, error) {
+	cons, isCons := c.(*consCell)
+	if !isCons {
+		return c, nil
+	}
+	if form, arg, isQuasiquoteForm := quasiquoteForm(cons); isQuasiquoteForm {
+		var expanded Cell
+		var err error
+		switch {
+		case form == "quasiquote":
+			expanded, err = expandTemplate(arg, depth+1, t)
+		case depth == 1:
+			expanded, err = expandForm(arg, t)
+		default:
+			expanded, err = expandTemplate(arg, depth-1, t)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return withParts(cons, cons.Car, withParts(cons.Cdr.(*consCell), expanded, nil)), nil
+	}
+	car, err := expandTemplate(cons.Car, depth, t)
+	if err != nil {
+		return nil, err
+	}
+	cdr, err := expandTemplate(cons.Cdr, depth, t)
+	if err != nil {
+		return nil, err
+	}
+	return withParts(cons, car, cdr), nil
+}
+
+// mapForms applies f to the elements of the list, with their index. The
+// conses are copied only if some element changes, keeping their positions
+// and their parallel evaluation
+func mapForms(list Cell, f func(i int, form Cell) (Cell, error)) (Cell, error) {
+	var conses []*consCell
+	var cars []Cell
+	changed := false
+	act := list
+	for i := 0; ; i++ {
+		cons, isCons := act.(*consCell)
+		if !isCons {
+			break
+		}
+		mapped, err := f(i, cons.Car)
+		if err != nil {
+			return nil, err
+		}
+		changed = changed || mapped != cons.Car
+		conses = append(conses, cons)
+		cars = append(cars, mapped)
+		act = cons.Cdr
+	}
+	if !changed {
+		return list, nil
+	}
+	// rebuilt from the end, act is the tail of one improper list
+	result := act
+	for i := len(conses) - 1; i >= 0; i-- {
+		copied := *conses[i]
+		copied.Car, copied.Cdr = cars[i], result
+		result = &copied
+	}
+	return result, nil
+}
+
+// withParts returns the cons with car and cdr, copying it only if they changed
+func withParts(cons *consCell, car, cdr Cell) Cell {
+	if car == cons.Car && cdr == cons.Cdr {
+		return cons
+	}
+	copied := *cons
+	copied.Car, copied.Cdr = car, cdr
+	return &copied
+}
+
+// quasiquoteForm tells if the cons is one form like `x, ,x or ,@x, and returns
+// the name of the form and x
+func quasiquoteForm(cons *consCell) (form string, arg Cell, isQuasiquoteForm bool) {
+	macro, isBuiltinMacro := cons.Car.(*builtinMacroCell)
+	if !isBuiltinMacro {
+		return "", nil, false
+	}
+	switch macro.Sym {
+	case "quasiquote", "unquote", "unquote-splicing":
+	default:
+		return "", nil, false
+	}
+	args, isCons := cons.Cdr.(*consCell)
+	if !isCons || args.Cdr != nil {
+		return "", nil, false
+	}
+	return macro.Sym, args.Car, true
+}
+
+// quasiquoteMacro returns the template like quote, but with the values of the
+// forms after one comma in place of them. The forms after ,@ must evaluate to
+// lists, whose elements are spliced in the list around them
+func quasiquoteMacro(args Cell, env *environmentEntry, t *task) EvalResult {
+	result, err := quasiquote(car(args), 1, env, t)
+	if err != nil {
+		return newEvalErrorResult(err)
+	}
+	return newEvalPositiveResult(result)
+}
+
+// quasiquote fills the template at the nesting level depth: only the commas
+// at level 1 are evaluated
+func quasiquote(template Cell, depth int, env *environmentEntry, t *task) (Cell, error) {
+	cons, isCons := template.(*consCell)
+	if !isCons {
+		return template, nil
+	}
+	if form, arg, isQuasiquoteForm := quasiquoteForm(cons); isQuasiquoteForm {
+		switch {
+		case form == "unquote" && depth == 1:
+			res := eval(arg, env, t)
+			return res.Cell, res.Err
+		case form == "unquote-splicing" && depth == 1:
+			return nil, newEvalError("[quasiquote] ,@ outside of one list")
+		case form == "quasiquote":
+			depth++
+		default:
+			depth--
+		}
+		filled, err := quasiquote(arg, depth, env, t)
+		if err != nil {
+			return nil, err
+		}
+		return withParts(cons, cons.Car, withParts(cons.Cdr.(*consCell), filled, nil)), nil
+	}
+	var top, last Cell
+	act := Cell(cons)
+	for {
+		actCons, isCons := act.(*consCell)
+		if !isCons {
+			break
+		}
+		if _, _, isQuasiquoteForm := quasiquoteForm(actCons); isQuasiquoteForm && act != template {
+			// the tail of one improper list, like ,x in (a . ,x)
+			break
+		}
+		if elements, isSplice, err := splice(actCons.Car, depth, env, t); err != nil {
+			return nil, err
+		} else if isSplice {
+			for i := range elements {
+				appendCellToArgs(&top, &last, &elements[i])
+			}
+		} else {
+			filled, err := quasiquote(actCons.Car, depth, env, t)
+			if err != nil {
+				return nil, err
+			}
+			appendCellToArgs(&top, &last, &filled)
+		}
+		act = actCons.Cdr
+	}
+	tail, err := quasiquote(act, depth, env, t)
+	if err != nil {
+		return nil, err
+	}
+	if top == nil {
+		return tail, nil
+	}
+	last.(*consCell).Cdr = tail
+	// `{f ,x} is one parallel form too
+	topCons := top.(*consCell)
+	topCons.Evlis, topCons.Parallel = cons.Evlis, cons.Parallel
+	return top, nil
+}
+
+// splice returns the elements to splice in place of the element of one
+// template, if it is ,@x at the nesting level 1
+func splice(element Cell, depth int, env *environmentEntry, t *task) ([]Cell, bool, error) {
+	cons, isCons := element.(*consCell)
+	if !isCons || depth != 1 {
+		return nil, false, nil
+	}
+	form, arg, isQuasiquoteForm := quasiquoteForm(cons)
+	if !isQuasiquoteForm || form != "unquote-splicing" {
+		return nil, false, nil
+	}
+	res := eval(arg, env, t)
+	if res.Err == nil {
+		res = touch(res.Cell, t)
+	}
+	if res.Err != nil {
+		return nil, false, res.Err
+	}
+	elements, err := listToSlice("unquote-splicing", res.Cell)
+	return elements, true, err
+}
+
+func unquoteMacro(args Cell, env *environmentEntry, t *task) EvalResult {
+	return newEvalErrorResult(newEvalError("[unquote] , outside of one quasiquote"))
+}
+
+func unquoteSplicingMacro(args Cell, env *environmentEntry, t *task) EvalResult {
+	return newEvalErrorResult(newEvalError("[unquote-splicing] ,@ outside of one quasiquote"))
+}
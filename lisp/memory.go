@@ -1,20 +1,48 @@
 package lisp
 
+import "math/big"
+
 func makeInt(i int) Cell {
 	return &intCell{i}
 }
 
+// makeBigInt returns one intCell if the value fits in it
+func makeBigInt(i *big.Int) Cell {
+	if i.IsInt64() {
+		if small := i.Int64(); small >= minInt && small <= maxInt {
+			return makeInt(int(small))
+		}
+	}
+	return &bigIntCell{i}
+}
+
+// makeRat returns one integer cell if the denominator is 1
+func makeRat(r *big.Rat) Cell {
+	if r.IsInt() {
+		return makeBigInt(new(big.Int).Set(r.Num()))
+	}
+	return &ratCell{r}
+}
+
+func makeFloat(f float64) Cell {
+	return &floatCell{f}
+}
+
 func makeString(s string) Cell {
 	return &stringCell{s}
 }
 
-func makeSymbol(s string) Cell {
-	if isBuiltin, builtinSymbol := lisp.isBuiltinSymbol(s); isBuiltin {
+func makeSymbol(lang *language, s string) Cell {
+	if isBuiltin, builtinSymbol := lang.isBuiltinSymbol(s); isBuiltin {
 		return builtinSymbol
 	}
 	return &symbolCell{s}
 }
 
+func makeClosure(params, body Cell, env *environmentEntry) Cell {
+	return &closureCell{params, body, env}
+}
+
 func makeCons(car Cell, cdr Cell) Cell {
-	return &consCell{car, cdr, evlisSequential, false}
+	return &consCell{car, cdr, evlisArgs, false, nil}
 }
@@ -0,0 +1,312 @@
+package lisp
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxInt = 1<<(strconv.IntSize-1) - 1
+	minInt = -1 << (strconv.IntSize - 1)
+)
+
+// arithmeticOperation is one arithmetic operation on the numbers. The result
+// is a float if one argument is a float, otherwise it is exact: small computes
+// it on ints, reporting false when the result is not an int. Only then big
+// computes it on big.Ints, or rat on big.Rats if big is nil or one argument
+// is a rational
+type arithmeticOperation struct {
+	small func(a, b int) (int, bool)
+	big   func(z, a, b *big.Int) *big.Int
+	rat   func(z, a, b *big.Rat) *big.Rat
+	float func(a, b float64) float64
+}
+
+var addition = arithmeticOperation{
+	small: func(a, b int) (int, bool) {
+		sum := a + b
+		return sum, (sum > a) == (b > 0)
+	},
+	big:   (*big.Int).Add,
+	rat:   (*big.Rat).Add,
+	float: func(a, b float64) float64 { return a + b },
+}
+
+var subtraction = arithmeticOperation{
+	small: func(a, b int) (int, bool) {
+		difference := a - b
+		return difference, (difference < a) == (b > 0)
+	},
+	big:   (*big.Int).Sub,
+	rat:   (*big.Rat).Sub,
+	float: func(a, b float64) float64 { return a - b },
+}
+
+var multiplication = arithmeticOperation{
+	small: func(a, b int) (int, bool) {
+		if a == 0 || b == 0 {
+			return 0, true
+		}
+		product := a * b
+		overflow := product/b != a || (a == -1 && b == minInt) || (b == -1 && a == minInt)
+		return product, !overflow
+	},
+	big:   (*big.Int).Mul,
+	rat:   (*big.Rat).Mul,
+	float: func(a, b float64) float64 { return a * b },
+}
+
+// division is exact, the divisor must not be zero
+var division = arithmeticOperation{
+	small: func(a, b int) (int, bool) {
+		if b == 0 || a%b != 0 || (a == minInt && b == -1) {
+			return 0, false
+		}
+		return a / b, true
+	},
+	rat:   (*big.Rat).Quo,
+	float: func(a, b float64) float64 { return a / b },
+}
+
+func (op arithmeticOperation) apply(a, b Cell) Cell {
+	if left, isInt := a.(*intCell); isInt {
+		if right, isInt := b.(*intCell); isInt {
+			if result, ok := op.small(left.Val, right.Val); ok {
+				return makeInt(result)
+			}
+		}
+	}
+	switch {
+	case isFloat(a) || isFloat(b):
+		return makeFloat(op.float(toFloat(a), toFloat(b)))
+	case op.big != nil && isInteger(a) && isInteger(b):
+		return makeBigInt(op.big(new(big.Int), toBigInt(a), toBigInt(b)))
+	default:
+		return makeRat(op.rat(new(big.Rat), toRat(a), toRat(b)))
+	}
+}
+
+// foldNumbers applies the operation to the arguments from left to right
+func foldNumbers(name string, initial Cell, args Cell, op arithmeticOperation) EvalResult {
+	tot := initial
+	for act := args; act != nil; act = cdr(act) {
+		n := car(act)
+		if !isNumber(n) {
+			return newEvalErrorResult(notANumber(name, n))
+		}
+		tot = op.apply(tot, n)
+	}
+	return newEvalPositiveResult(tot)
+}
+
+// compareNumbers returns -1, 0 or +1 if a is less than, equal to or
+// greater than b
+func compareNumbers(a, b Cell) int {
+	if left, isInt := a.(*intCell); isInt {
+		if right, isInt := b.(*intCell); isInt {
+			switch {
+			case left.Val < right.Val:
+				return -1
+			case left.Val > right.Val:
+				return 1
+			default:
+				return 0
+			}
+		}
+	}
+	switch {
+	case isFloat(a) || isFloat(b):
+		left, right := toFloat(a), toFloat(b)
+		switch {
+		case left < right:
+			return -1
+		case left > right:
+			return 1
+		default:
+			return 0
+		}
+	case isInteger(a) && isInteger(b):
+		return toBigInt(a).Cmp(toBigInt(b))
+	default:
+		return toRat(a).Cmp(toRat(b))
+	}
+}
+
+// rounding rounds the numbers to integers, rat rounds the rational num/den
+// where den is positive
+type rounding struct {
+	float func(float64) float64
+	rat   func(num, den *big.Int) *big.Int
+}
+
+var floorRounding = rounding{
+	float: math.Floor,
+	// the Euclidean division rounds toward negative infinity if den > 0
+	rat: func(num, den *big.Int) *big.Int { return new(big.Int).Div(num, den) },
+}
+
+var ceilingRounding = rounding{
+	float: math.Ceil,
+	rat: func(num, den *big.Int) *big.Int {
+		floor := new(big.Int).Div(new(big.Int).Neg(num), den)
+		return floor.Neg(floor)
+	},
+}
+
+var truncateRounding = rounding{
+	float: math.Trunc,
+	rat:   func(num, den *big.Int) *big.Int { return new(big.Int).Quo(num, den) },
+}
+
+// roundRounding rounds to the nearest integer, to the even one if two are
+// equally near
+var roundRounding = rounding{
+	float: math.RoundToEven,
+	rat: func(num, den *big.Int) *big.Int {
+		floor, remainder := new(big.Int).DivMod(num, den, new(big.Int))
+		switch remainder.Lsh(remainder, 1).Cmp(den) {
+		case 1:
+			floor.Add(floor, big.NewInt(1))
+		case 0:
+			if floor.Bit(0) == 1 {
+				floor.Add(floor, big.NewInt(1))
+			}
+		}
+		return floor
+	},
+}
+
+func (r rounding) apply(name string, c Cell) (Cell, error) {
+	switch n := c.(type) {
+	case *ratCell:
+		return makeBigInt(r.rat(n.Val.Num(), n.Val.Denom())), nil
+	case *floatCell:
+		rounded := r.float(n.Val)
+		if math.IsInf(rounded, 0) || math.IsNaN(rounded) {
+			return nil, newTypeError(name, n, "a finite number")
+		}
+		if rounded >= minInt && rounded < -minInt {
+			return makeInt(int(rounded)), nil
+		}
+		integer, _ := big.NewFloat(rounded).Int(nil)
+		return makeBigInt(integer), nil
+	default:
+		return c, nil
+	}
+}
+
+func isNumber(c Cell) bool {
+	switch c.(type) {
+	case *intCell, *bigIntCell, *ratCell, *floatCell:
+		return true
+	default:
+		return false
+	}
+}
+
+func isInteger(c Cell) bool {
+	switch c.(type) {
+	case *intCell, *bigIntCell:
+		return true
+	default:
+		return false
+	}
+}
+
+func isFloat(c Cell) bool {
+	_, isFloat := c.(*floatCell)
+	return isFloat
+}
+
+// isZero tells if the number is zero, the big integers and the rationals
+// never are
+func isZero(c Cell) bool {
+	switch n := c.(type) {
+	case *intCell:
+		return n.Val == 0
+	case *floatCell:
+		return n.Val == 0
+	default:
+		return false
+	}
+}
+
+// toBigInt converts one integer cell, the result must not be modified
+func toBigInt(c Cell) *big.Int {
+	switch n := c.(type) {
+	case *intCell:
+		return big.NewInt(int64(n.Val))
+	case *bigIntCell:
+		return n.Val
+	default:
+		return nil
+	}
+}
+
+// toRat converts one exact number, the result must not be modified
+func toRat(c Cell) *big.Rat {
+	switch n := c.(type) {
+	case *intCell:
+		return new(big.Rat).SetInt64(int64(n.Val))
+	case *bigIntCell:
+		return new(big.Rat).SetInt(n.Val)
+	case *ratCell:
+		return n.Val
+	default:
+		return nil
+	}
+}
+
+func toFloat(c Cell) float64 {
+	switch n := c.(type) {
+	case *intCell:
+		return float64(n.Val)
+	case *bigIntCell:
+		f, _ := new(big.Float).SetInt(n.Val).Float64()
+		return f
+	case *ratCell:
+		f, _ := n.Val.Float64()
+		return f
+	case *floatCell:
+		return n.Val
+	default:
+		return math.NaN()
+	}
+}
+
+// parseNumber parses one number literal: integers of any size, rationals
+// like 7/2 and floats like 3.14 or 1e-9
+func parseNumber(literal string) (Cell, bool) {
+	switch {
+	case strings.Contains(literal, "/"):
+		r, ok := new(big.Rat).SetString(literal)
+		if !ok {
+			return nil, false
+		}
+		return makeRat(r), true
+	case strings.ContainsAny(literal, ".eE"):
+		f, err := strconv.ParseFloat(literal, 64)
+		if err != nil {
+			return nil, false
+		}
+		return makeFloat(f), true
+	}
+	n, err := strconv.Atoi(literal)
+	if err == nil {
+		return makeInt(n), true
+	}
+	if numErr, isNumErr := err.(*strconv.NumError); !isNumErr || numErr.Err != strconv.ErrRange {
+		return nil, false
+	}
+	b, ok := new(big.Int).SetString(literal, 10)
+	if !ok {
+		return nil, false
+	}
+	return makeBigInt(b), true
+}
+
+func notANumber(name string, c Cell) EvalError {
+	return newTypeError(name, c, "a number")
+}
@@ -2,96 +2,173 @@ package lisp
 
 import (
 	"fmt"
+	"io"
+	"strings"
 )
 
 // Parse returns the result, if there were errors parsing and eventually one error message
-func Parse(source string) (Cell, error) {
-	sexpressions, err := parseMultipleSexpressions(source)
-	if len(sexpressions) > 1 {
-		return nil, ParseError{"[parser] too many sexpressions"}
+func (interp *Interpreter) Parse(source string) (Cell, error) {
+	return interp.parse(source, Pos{Line: 1, Column: 1})
+}
+
+// parse parses one sexpression, the source starts at the position start
+func (interp *Interpreter) parse(source string, start Pos) (Cell, error) {
+	r := newReader(interp.lang, strings.NewReader(source), start)
+	sexpression, err := r.read()
+	if err == io.EOF {
+		return nil, ParseError{err: "empty source"}
 	}
 	if err != nil {
 		return nil, err
 	}
-	return sexpressions[0], nil
+	if _, err := r.read(); err != io.EOF {
+		if err != nil {
+			return nil, err
+		}
+		return nil, ParseError{err: "[parser] too many sexpressions"}
+	}
+	return sexpression, nil
 }
 
-// parseMultipleSexpressions resturns the array of parsed sexpressions
-func parseMultipleSexpressions(source string) ([]Cell, error) {
-	tokens := tokenize(source)
-	if len(tokens) == 1 && tokens[0].typ == tokNone {
-		return nil, ParseError{"empty source"}
-	}
+// reader parses the sexpressions of one source as they are needed, reading
+// only the tokens of the next one
+type reader struct {
+	lang    *language
+	scanner *scanner
+	// next is the token peeked but not extracted yet, if hasNext
+	next    token
+	hasNext bool
+	// last is the last token extracted, where the lists end
+	last token
+}
 
-	var tokensIndex = 0
-	var result []Cell
+// newReader reads the source, starting at the position start
+func newReader(lang *language, source io.Reader, start Pos) *reader {
+	return &reader{lang: lang, scanner: newScanner(source, start), last: token{end: start}}
+}
 
-	for enoughTokens(tokens, &tokensIndex) {
-		actualSexpression, err := ricParse(tokens, &tokensIndex)
-		if err != nil {
-			return nil, err
-		}
-		result = append(result, actualSexpression)
+// read returns the next sexpression of the source, io.EOF at its end
+func (r *reader) read() (Cell, error) {
+	tok, err := r.peekToken()
+	if err != nil {
+		return nil, err
+	}
+	if tok.typ == tokNone {
+		return nil, io.EOF
 	}
-	return result, nil
+	return r.ricParse()
 }
 
-func ricParse(tokens []token, tokensIndex *int) (Cell, error) {
-	actualToken, err := extractNextToken(tokens, tokensIndex)
+func (r *reader) ricParse() (Cell, error) {
+	actualToken, err := r.extractNextToken()
 	if err != nil {
 		return nil, err
 	}
 
 	switch actualToken.typ {
 	case tokNum:
-		newInt := makeInt(actualToken.val)
-		return newInt, nil
+		newNum, isNumber := parseNumber(actualToken.str)
+		if !isNumber {
+			return nil, ParseError{err: "invalid number " + actualToken.str, Pos: actualToken.pos}
+		}
+		return newNum, nil
 	case tokStr:
 		newStr := makeString(actualToken.str)
 		return newStr, nil
 	case tokSym:
-		newSym := makeSymbol(actualToken.str)
+		newSym := makeSymbol(r.lang, actualToken.str)
 		return newSym, nil
-	case tokQuote:
-		return buildQuote(tokens, tokensIndex)
+	case tokQuote, tokQuasiquote, tokUnquote, tokUnquoteSplice:
+		quote, err := r.buildQuote(quoteSymbols[actualToken.typ])
+		if err != nil {
+			return nil, err
+		}
+		r.setSpan(quote, actualToken)
+		return quote, nil
 	case tokOpen:
-		return buildCons(tokens, tokOpen, tokClose, tokensIndex)
+		cons, err := r.buildCons(actualToken, tokClose)
+		if err != nil {
+			return nil, err
+		}
+		r.setSpan(cons, actualToken)
+		return cons, nil
 	case tokOpenParallel:
-		cons, err := buildCons(tokens, tokCloseParallel, tokCloseParallel, tokensIndex)
+		cons, err := r.buildCons(actualToken, tokCloseParallel)
 		if err != nil {
 			return nil, err
 		}
-		(*(cons.(*consCell))).Evlis = evlisParallel
+		if cons == nil {
+			return nil, nil
+		}
+		(*(cons.(*consCell))).Evlis = evlisParallelArgs
 		(*(cons.(*consCell))).Parallel = true
+		r.setSpan(cons, actualToken)
 		return cons, nil
 	default:
-		return nil, ParseError{"parse error near token " + fmt.Sprintf("%v", actualToken)}
+		return nil, ParseError{err: "parse error near token " + fmt.Sprintf("%v", actualToken), Pos: actualToken.pos}
+	}
+}
+
+// setSpan records that the list was parsed from the first token to the
+// last one extracted
+func (r *reader) setSpan(c Cell, first token) {
+	if cons, isCons := c.(*consCell); isCons {
+		cons.Span = &Span{Start: first.pos, End: r.last.end}
+	}
+}
+
+// peekToken returns the next token without extracting it, one tokNone at the
+// end of the source
+func (r *reader) peekToken() (token, error) {
+	if !r.hasNext {
+		tok, err := r.scanner.readToken()
+		if err != nil {
+			return tok, err
+		}
+		r.next, r.hasNext = tok, true
 	}
+	return r.next, nil
 }
 
-func extractNextToken(tokens []token, tokensIndex *int) (token, error) {
-	if !enoughTokens(tokens, tokensIndex) {
-		return token{typ: tokNone}, ParseError{"tokens ended"}
+func (r *reader) extractNextToken() (token, error) {
+	tok, err := r.peekToken()
+	if err != nil {
+		return tok, err
 	}
-	tok := tokens[*tokensIndex]
-	(*tokensIndex)++
+	if tok.typ == tokNone {
+		return tok, ParseError{err: "tokens ended", Pos: r.last.end}
+	}
+	r.hasNext = false
+	r.last = tok
 	return tok, nil
 }
 
-func readNextToken(tokens []token, tokensIndex *int) (token, error) {
-	if !enoughTokens(tokens, tokensIndex) {
-		return token{typ: tokNone}, ParseError{"parenthesis not closed"}
+// readNextToken returns the next token of the list opened by open, without
+// extracting it
+func (r *reader) readNextToken(open token) (token, error) {
+	tok, err := r.peekToken()
+	if err != nil {
+		return tok, err
 	}
-	return tokens[(*tokensIndex)], nil
+	if tok.typ == tokNone {
+		return tok, ParseError{err: "parenthesis not closed", Pos: open.pos}
+	}
+	return tok, nil
 }
 
-func enoughTokens(tokens []token, tokensIndex *int) bool {
-	return (*tokensIndex) < len(tokens)
+// quoteSymbols are the forms read from the quote tokens: 'x is (quote x)
+var quoteSymbols = map[tokenType]string{
+	tokQuote:         "quote",
+	tokQuasiquote:    "quasiquote",
+	tokUnquote:       "unquote",
+	tokUnquoteSplice: "unquote-splicing",
 }
 
-func buildQuote(tokens []token, tokensIndex *int) (Cell, error) {
-	quoteSym := makeSymbol("quote")
-	quotedSexpression, err := ricParse(tokens, tokensIndex)
+// buildQuote reads the sexpression after one quote token, and returns it
+// wrapped in the form named quoteName
+func (r *reader) buildQuote(quoteName string) (Cell, error) {
+	quoteSym := makeSymbol(r.lang, quoteName)
+	quotedSexpression, err := r.ricParse()
 	if err != nil {
 		return nil, err
 	}
@@ -101,51 +178,51 @@ func buildQuote(tokens []token, tokensIndex *int) (Cell, error) {
 	return topCons, nil
 }
 
-func buildCons(tokens []token, openParToken, closeParToken tokenType, tokensIndex *int) (Cell, error) {
-	nextToken, err := readNextToken(tokens, tokensIndex)
+func (r *reader) buildCons(open token, closeParToken tokenType) (Cell, error) {
+	nextToken, err := r.readNextToken(open)
 	if err != nil {
 		return nil, err
 	}
 	if nextToken.typ == closeParToken {
-		extractNextToken(tokens, tokensIndex)
+		r.extractNextToken()
 		return nil, nil
 	}
-	left, err := ricParse(tokens, tokensIndex)
+	left, err := r.ricParse()
 	if err != nil {
 		return nil, err
 	}
 	top := makeCons(left, nil)
 	actCons := top
 
-	nextToken, err = readNextToken(tokens, tokensIndex)
+	nextToken, err = r.readNextToken(open)
 	if err != nil {
 		return nil, err
 	}
 	if nextToken.typ == closeParToken {
-		extractNextToken(tokens, tokensIndex)
+		r.extractNextToken()
 		return top, nil
 	}
 
 	for {
-		actualToken, err := readNextToken(tokens, tokensIndex)
+		actualToken, err := r.readNextToken(open)
 		if err != nil {
 			return nil, err
 		}
 		if actualToken.typ == tokDot {
-			extractNextToken(tokens, tokensIndex) // extract the dot
+			r.extractNextToken() // extract the dot
 			// last element
-			right, err := ricParse(tokens, tokensIndex)
+			right, err := r.ricParse()
 
 			if err != nil {
 				return nil, err
 			}
-			closePar, err := extractNextToken(tokens, tokensIndex)
+			closePar, err := r.extractNextToken()
 			if err != nil {
 				return nil, err
 			}
 
 			if closePar.typ != closeParToken {
-				return nil, ParseError{"parenthesis not closed near " + fmt.Sprintf("%v", right)}
+				return nil, ParseError{err: "parenthesis not closed near " + fmt.Sprintf("%v", right), Pos: closePar.pos}
 			}
 			switch cons := actCons.(type) {
 			case *consCell:
@@ -153,7 +230,7 @@ func buildCons(tokens []token, openParToken, closeParToken tokenType, tokensInde
 			}
 			return top, nil
 		}
-		right, err := ricParse(tokens, tokensIndex)
+		right, err := r.ricParse()
 		if err != nil {
 			return nil, err
 		}
@@ -171,13 +248,13 @@ func buildCons(tokens []token, openParToken, closeParToken tokenType, tokensInde
 			actCons = (*cons).Cdr
 		}
 
-		maybeClosePar, err := readNextToken(tokens, tokensIndex)
+		maybeClosePar, err := r.readNextToken(open)
 		if err != nil {
 			return nil, err
 		}
 
 		if maybeClosePar.typ == closeParToken {
-			extractNextToken(tokens, tokensIndex)
+			r.extractNextToken()
 			return top, nil
 		}
 	}
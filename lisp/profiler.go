@@ -0,0 +1,156 @@
+package lisp
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// profiler collects the statistics of the {} forms evaluated while profiling.
+// Every method works on a nil profiler, doing nothing
+type profiler struct {
+	mutex sync.Mutex
+	sites map[*consCell]*siteStats
+}
+
+// siteStats are the statistics of one {} form of the source. The times are
+// in nanoseconds
+type siteStats struct {
+	form        *consCell
+	evaluations int64
+	// spawns counts the arguments evaluated by the workers
+	spawns int64
+	// live counts the spawned arguments running now
+	live     int32
+	peakLive int32
+	// branchTime is the time spent evaluating the spawned arguments
+	branchTime int64
+	// waitTime is the time the form waited for the spawned arguments, after
+	// evaluating the others inline
+	waitTime int64
+}
+
+func newProfiler() *profiler {
+	return &profiler{sites: make(map[*consCell]*siteStats)}
+}
+
+// site returns the statistics of the form, nil if p is nil
+func (p *profiler) site(form *consCell) *siteStats {
+	if p == nil {
+		return nil
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	stats, found := p.sites[form]
+	if !found {
+		stats = &siteStats{form: form}
+		p.sites[form] = stats
+	}
+	return stats
+}
+
+func (s *siteStats) evaluated() {
+	if s != nil {
+		atomic.AddInt64(&s.evaluations, 1)
+	}
+}
+
+// branchStarted returns the start time of one spawned argument
+func (s *siteStats) branchStarted() time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	atomic.AddInt64(&s.spawns, 1)
+	live := atomic.AddInt32(&s.live, 1)
+	for {
+		peak := atomic.LoadInt32(&s.peakLive)
+		if live <= peak || atomic.CompareAndSwapInt32(&s.peakLive, peak, live) {
+			break
+		}
+	}
+	return time.Now()
+}
+
+func (s *siteStats) branchFinished(start time.Time) {
+	if s != nil {
+		atomic.AddInt64(&s.branchTime, int64(time.Since(start)))
+		atomic.AddInt32(&s.live, -1)
+	}
+}
+
+// waitStarted returns the time the form started waiting for its arguments
+func (s *siteStats) waitStarted() time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+func (s *siteStats) waitFinished(start time.Time) {
+	if s != nil {
+		atomic.AddInt64(&s.waitTime, int64(time.Since(start)))
+	}
+}
+
+// benefit estimates the wall clock time saved by the form: the spawned
+// arguments would have been evaluated inline, instead the form waited for them
+func (s *siteStats) benefit() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.branchTime) - atomic.LoadInt64(&s.waitTime))
+}
+
+// report prints the statistics of the forms, the most beneficial first
+func (p *profiler) report(out io.Writer) {
+	p.mutex.Lock()
+	sites := make([]*siteStats, 0, len(p.sites))
+	for _, stats := range p.sites {
+		sites = append(sites, stats)
+	}
+	p.mutex.Unlock()
+	if len(sites) == 0 {
+		fmt.Fprintln(out, "profile: no {} form evaluated")
+		return
+	}
+	sort.Slice(sites, func(i, j int) bool { return sites[i].benefit() > sites[j].benefit() })
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "evals\tspawns\tpeak live\tbranch time\tmean branch\twait\tbenefit\t\tform")
+	for _, stats := range sites {
+		spawns := atomic.LoadInt64(&stats.spawns)
+		branchTime := time.Duration(atomic.LoadInt64(&stats.branchTime))
+		var mean time.Duration
+		if spawns > 0 {
+			mean = branchTime / time.Duration(spawns)
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t\t%v\n",
+			atomic.LoadInt64(&stats.evaluations),
+			spawns,
+			atomic.LoadInt32(&stats.peakLive),
+			roundDuration(branchTime),
+			roundDuration(mean),
+			roundDuration(time.Duration(atomic.LoadInt64(&stats.waitTime))),
+			roundDuration(stats.benefit()),
+			shortCell(stats.form))
+	}
+	w.Flush()
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	if d > time.Millisecond || d < -time.Millisecond {
+		return d.Round(time.Millisecond / 10)
+	}
+	return d.Round(time.Microsecond)
+}
+
+// shortCell prints the cell on one short line
+func shortCell(c Cell) string {
+	const maxLength = 60
+	s := []rune(fmt.Sprintf("%v", c))
+	if len(s) > maxLength {
+		return string(s[:maxLength-4]) + " ..."
+	}
+	return string(s)
+}
@@ -2,8 +2,15 @@ package lisp
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync/atomic"
 
 	"github.com/logrusorgru/aurora"
 )
@@ -13,9 +20,140 @@ type Cell interface {
 	Eq(Cell) bool
 }
 
+// Config contains the settings of one Interpreter
+type Config struct {
+	// Workers is the number of workers that evaluate the arguments of {} forms
+	// in parallel, one per cpu if not set. When all of them are busy the
+	// arguments are evaluated sequentially.
+	Workers int
+	// Output is where write, time and the Repl print, os.Stdout if not set
+	Output io.Writer
+	// Sequential makes every {} form evaluate like a () one, and the other
+	// parallel primitives run their branches in order, see SetSequential
+	Sequential bool
+}
+
+// Interpreter is one isolated instance of the language: the definitions made
+// in one interpreter are not visible in the others
+type Interpreter struct {
+	config    Config
+	lang      *language
+	globalEnv *globalEnvironment
+	sched     *scheduler
+	// sequential is 1 in sequential mode
+	sequential int32
+	// profiling holds the *profiler of the evaluations started by the Repl,
+	// nil when they are not profiled
+	profiling atomic.Value
+	// tracing holds the *tracer of all the evaluations, nil when not tracing
+	tracing atomic.Value
+}
+
+// NewInterpreter returns one interpreter, already initialized. Close must be
+// called to release its workers
+func NewInterpreter(config Config) *Interpreter {
+	if config.Workers <= 0 {
+		config.Workers = runtime.NumCPU()
+	}
+	if config.Output == nil {
+		config.Output = os.Stdout
+	}
+	interp := &Interpreter{
+		config: config,
+		sched:  newScheduler(config.Workers),
+	}
+	interp.SetSequential(config.Sequential)
+	interp.init()
+	return interp
+}
+
+// SetSequential turns on and off the sequential mode, useful to debug the
+// parallel programs: the arguments of {} forms, the bindings of plet, the
+// arguments of por and pand and the chunks of the parallel list functions are
+// evaluated in order, futures are evaluated as soon as they are created.
+// The evaluations already running are affected too
+func (interp *Interpreter) SetSequential(sequential bool) {
+	var value int32
+	if sequential {
+		value = 1
+	}
+	atomic.StoreInt32(&interp.sequential, value)
+}
+
+// IsSequential tells if the interpreter is in sequential mode
+func (interp *Interpreter) IsSequential() bool {
+	return atomic.LoadInt32(&interp.sequential) == 1
+}
+
+// StartTrace starts recording the trace of the evaluations
+func (interp *Interpreter) StartTrace() {
+	interp.tracing.Store(newTracer())
+}
+
+// StopTrace stops recording the trace of the evaluations and writes it to
+// the file, in the Chrome Trace Event format. It does nothing if the trace
+// was not started
+func (interp *Interpreter) StopTrace(path string) error {
+	tr := interp.replTracer()
+	if tr == nil {
+		return nil
+	}
+	interp.tracing.Store((*tracer)(nil))
+	return tr.writeFile(path)
+}
+
+func (interp *Interpreter) replTracer() *tracer {
+	tr, _ := interp.tracing.Load().(*tracer)
+	return tr
+}
+
+// init creates the language and the global environment, before the
+// interpreter is shared
+func (interp *Interpreter) init() {
+	interp.lang = newLanguage()
+	interp.globalEnv = newGlobalEnvironment()
+	interp.initGlobalEnv()
+}
+
+// Close stops the workers of the interpreter: the evaluations already running
+// go on sequentially, the ones started afterwards fail with one ClosedError
+func (interp *Interpreter) Close() {
+	interp.sched.stop()
+}
+
 // Eval evaluates one sexpression in the empty environment
-func Eval(c Cell) EvalResult {
-	return eval(c, emptyEnv())
+func (interp *Interpreter) Eval(c Cell) EvalResult {
+	return interp.EvalContext(context.Background(), c)
+}
+
+// EvalContext evaluates one sexpression in the empty environment, stopping as
+// soon as ctx is done. In that case the error of the result is a TimeoutError
+// if the deadline of ctx expired, a CancelledError otherwise.
+// The evaluation sees the global definitions as they were when it started.
+// It is safe to call EvalContext from many goroutines. The calls of the
+// macros defined with defmacro are expanded before the evaluation.
+// The futures in the result, in its lists too, are done when EvalContext
+// returns: the other futures of the evaluation still running are cancelled
+func (interp *Interpreter) EvalContext(ctx context.Context, c Cell) EvalResult {
+	if interp.sched.isStopped() {
+		return newEvalErrorResult(ClosedError{})
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	t := newRootTask(ctx, interp)
+	expanded, err := expandMacros(c, t)
+	if err != nil {
+		return newEvalErrorResult(err)
+	}
+	result := safeEval(expanded, emptyEnv(), t)
+	if result.Err != nil {
+		return result
+	}
+	result = touch(result.Cell, t)
+	if result.Err == nil {
+		waitFutures(result.Cell)
+	}
+	return result
 }
 
 // EvalResult result contains the result of one evaluation.
@@ -27,22 +165,178 @@ type EvalResult struct {
 
 // EvalError represents the error of a computation
 type EvalError struct {
-	Err string
+	Err  string
+	Kind ErrorKind
+	// Pos is the position of the innermost form which failed, if it was parsed
+	Pos Pos
+	// frames is the outermost frame of the trace, see Trace
+	frames *traceFrame
+	// unboundSymbol is set when the error is due to one symbol not bound
+	unboundSymbol string
+	// boundByCaller is set if the symbol was bound by one calling function
+	boundByCaller bool
 }
 
 func (e EvalError) Error() string {
+	if e.Pos.IsValid() {
+		return e.Pos.String() + ": " + e.Err
+	}
 	return e.Err
 }
 
+// Trace returns the functions which were being applied when the error
+// happened, the innermost first, and the arguments of the parallel forms it
+// went through. The functions called in tail position replace their caller,
+// which is not in the trace
+func (e EvalError) Trace() []Frame {
+	var trace []Frame
+	for frame := e.frames; frame != nil; frame = frame.inner {
+		trace = append(trace, frame.Frame)
+	}
+	for i, j := 0, len(trace)-1; i < j; i, j = i+1, j-1 {
+		trace[i], trace[j] = trace[j], trace[i]
+	}
+	return trace
+}
+
+// Frame is one step of the trace of one EvalError
+type Frame struct {
+	// Function is the name of the function applied, λ if it has no name, or
+	// the parallel form the error went through: {}, por, pand, plet, pmap,
+	// pfilter or preduce
+	Function string
+	// Branch is the part of the parallel form which failed, from 1: the
+	// argument of {}, por and pand, the binding of plet, the chunk of the list
+	// of pmap, pfilter and preduce. It is 0 in the frames of the applications
+	Branch int
+	// Pos is the call site of the function, or the argument which failed,
+	// if it was parsed
+	Pos Pos
+}
+
+// branchNames are the parts of the parallel forms whose branches are not
+// their arguments
+var branchNames = map[string]string{
+	"plet":    "binding",
+	"pmap":    "chunk",
+	"pfilter": "chunk",
+	"preduce": "chunk",
+}
+
+func (f Frame) String() string {
+	s := f.Function
+	if f.Branch > 0 {
+		branch, found := branchNames[f.Function]
+		if !found {
+			branch = "argument"
+		}
+		s = fmt.Sprintf("%v %v %v", f.Function, branch, f.Branch)
+	}
+	if f.Pos.IsValid() {
+		s += " (" + f.Pos.String() + ")"
+	}
+	return s
+}
+
+// ErrorKind classifies the errors of the evaluation
+type ErrorKind int
+
+const (
+	// GenericError is every error with no more specific kind
+	GenericError ErrorKind = iota
+	// TypeError is one argument of the wrong type
+	TypeError
+	// ArityError is one builtin called with the wrong number of arguments
+	ArityError
+	// InternalError is one panic of the interpreter, which was recovered
+	InternalError
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case TypeError:
+		return "type error"
+	case ArityError:
+		return "arity error"
+	case InternalError:
+		return "internal error"
+	default:
+		return "error"
+	}
+}
+
+// TimeoutError is the error of one evaluation stopped by the deadline of its context
+type TimeoutError struct{}
+
+func (e TimeoutError) Error() string {
+	return "[eval] evaluation timed out"
+}
+
+// Unwrap allows errors.Is(err, context.DeadlineExceeded)
+func (e TimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// CancelledError is the error of one evaluation stopped because its context
+// was cancelled, or because one parallel sibling failed
+type CancelledError struct{}
+
+func (e CancelledError) Error() string {
+	return "[eval] evaluation cancelled"
+}
+
+// Unwrap allows errors.Is(err, context.Canceled)
+func (e CancelledError) Unwrap() error {
+	return context.Canceled
+}
+
+// ClosedError is the error of the evaluations started after Close
+type ClosedError struct{}
+
+func (e ClosedError) Error() string {
+	return "[eval] the interpreter is closed"
+}
+
 // ParseError represents one error found during the paring
 type ParseError struct {
 	err string
+	Pos Pos
 }
 
 func (e ParseError) Error() string {
+	if e.Pos.IsValid() {
+		return e.Pos.String() + ": " + e.err
+	}
 	return e.err
 }
 
+// Pos is one position in the source, the zero value is unknown. Lines and
+// columns start from 1, columns count the runes
+type Pos struct {
+	File   string
+	Line   int
+	Column int
+}
+
+// IsValid tells if the position is known
+func (p Pos) IsValid() bool {
+	return p.Line > 0
+}
+
+// String returns file:line:column, or line:column if the source is not a file
+func (p Pos) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%v:%v", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%v:%v:%v", p.File, p.Line, p.Column)
+}
+
+// Span is the part of the source from Start to End, excluded
+type Span struct {
+	Start Pos
+	End   Pos
+}
+
 // SemanticError represents one error found during the semantic analysis
 type SemanticError struct {
 	errorString string
@@ -52,45 +346,228 @@ func (e *SemanticError) Error() string {
 	return e.errorString
 }
 
-// Init initializes the needed variables. Must be called before using any lisp structure
-func Init() {
-	initLanguage()
-	initGlobalEnv()
+// Repl performs the read-eval-printline loop on the standard input
+func (interp *Interpreter) Repl() {
+	out := interp.config.Output
+	input := &replInput{interp: interp, out: out, lines: bufio.NewReader(os.Stdin)}
+	r := newReader(interp.lang, input, Pos{File: replFile, Line: 1, Column: 1})
+	for {
+		// Read: the first token is read at the prompt, the rest of the
+		// sexpression on as many lines as needed
+		input.continuing = false
+		if _, err := r.peekToken(); err != nil {
+			printError(out, err)
+			printSnippet(out, err, input.history)
+			r = input.restart()
+			continue
+		}
+		input.continuing = true
+		sexpr, err := r.read()
+		if err == io.EOF {
+			fmt.Fprintln(out, "  Bye!")
+			return
+		}
+		if err != nil {
+			printError(out, err)
+			printSnippet(out, err, input.history)
+			r = input.restart()
+			continue
+		}
+		// Semantic Analysis
+		if ok, err := SemanticAnalysis(sexpr); !ok {
+			printError(out, err)
+			r = input.restart()
+			continue
+		}
+		// Eval
+		result := interp.Eval(sexpr)
+		if result.Err != nil {
+			printError(out, result.Err)
+			printSnippet(out, result.Err, input.history)
+			printTrace(out, result.Err)
+			printScopingWarning(out, result.Err)
+			r = input.restart()
+			continue
+		}
+		fmt.Fprintln(out, " ", result.Cell, aurora.BrightGreen("✓"))
+	}
 }
 
-// Repl performs the read-eval-printline loop
-func Repl() {
-	Init()
-	reader := bufio.NewReader(os.Stdin)
-	for {
-		// Read
-		fmt.Print(aurora.BrightBlue("≃ "))
-		source, _ := reader.ReadString('\n')
-		if source == "\n" {
-			fmt.Println("  Bye!")
+// replInput is the standard input of the Repl, read one line at a time as
+// the reader needs it: it prints the prompts, runs the commands and keeps the
+// lines, which are the lines of the file replFile in the positions
+type replInput struct {
+	interp *Interpreter
+	out    io.Writer
+	lines  *bufio.Reader
+	// continuing is true while the reader is in the middle of one
+	// sexpression: the lines are not commands, and one blank line does not quit
+	continuing bool
+	history    []string
+	// pending is the rest of the last line, which did not fit in the buffer
+	pending string
+	// done is true once the input ended, or one blank line quit
+	done bool
+}
+
+func (in *replInput) Read(p []byte) (int, error) {
+	for in.pending == "" {
+		if in.done {
+			return 0, io.EOF
+		}
+		if in.continuing {
+			fmt.Fprint(in.out, aurora.BrightBlue("… "))
+		} else {
+			fmt.Fprint(in.out, aurora.BrightBlue("≃ "))
+		}
+		line, err := in.lines.ReadString('\n')
+		if line == "" || (!in.continuing && strings.TrimSpace(line) == "") {
+			in.done = true
+			if err != nil && err != io.EOF {
+				return 0, err
+			}
+			continue
+		}
+		if !in.continuing && strings.HasPrefix(line, ":") {
+			in.interp.replCommand(in.out, strings.TrimSpace(line))
+			continue
+		}
+		in.history = append(in.history, strings.TrimSuffix(line, "\n"))
+		in.pending = line
+		if code := strings.TrimSpace(line); code != "" && !strings.HasPrefix(code, ";") {
+			// the first token, which may continue on the next lines
+			in.continuing = true
+		}
+	}
+	n := copy(p, in.pending)
+	in.pending = in.pending[n:]
+	return n, nil
+}
+
+// restart returns one reader of the next lines, dropping the rest of the
+// current one after one error
+func (in *replInput) restart() *reader {
+	in.pending = ""
+	return newReader(in.interp.lang, in, Pos{File: replFile, Line: len(in.history) + 1, Column: 1})
+}
+
+// replCommands are the commands of the Repl, prefixed by one colon
+var replCommands = map[string]func(interp *Interpreter, out io.Writer){
+	":sequential": func(interp *Interpreter, out io.Writer) {
+		interp.SetSequential(true)
+		fmt.Fprintln(out, " ", "{} forms are evaluated sequentially", aurora.BrightGreen("✓"))
+	},
+	":parallel": func(interp *Interpreter, out io.Writer) {
+		interp.SetSequential(false)
+		fmt.Fprintln(out, " ", "{} forms are evaluated in parallel", aurora.BrightGreen("✓"))
+	},
+	":profile": func(interp *Interpreter, out io.Writer) {
+		if prof := interp.replProfiler(); prof != nil {
+			interp.profiling.Store((*profiler)(nil))
+			prof.report(out)
 			return
 		}
-		// Parse
-		sexpr, err := Parse(source)
+		interp.profiling.Store(newProfiler())
+		fmt.Fprintln(out, " ", "profiling the {} forms until the next :profile", aurora.BrightGreen("✓"))
+	},
+}
+
+// replProfiler returns the profiler of the evaluations, nil if not profiling
+func (interp *Interpreter) replProfiler() *profiler {
+	prof, _ := interp.profiling.Load().(*profiler)
+	return prof
+}
+
+func (interp *Interpreter) replCommand(out io.Writer, command string) {
+	run, isCommand := replCommands[command]
+	if !isCommand {
+		names := make([]string, 0, len(replCommands))
+		for name := range replCommands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		printError(out, fmt.Errorf("unknown command %v, the commands are %v", command, strings.Join(names, " ")))
+		return
+	}
+	run(interp, out)
+}
+
+func printError(out io.Writer, e error) {
+	fmt.Fprintln(out, " ", aurora.BrightRed(e), aurora.BrightRed("✗"))
+}
+
+// replFile is the name of the inputs of the Repl in the positions
+const replFile = "<repl>"
+
+// printSnippet prints the line of the source where the error is, with one
+// caret under its column. The lines of the loaded files are read again
+func printSnippet(out io.Writer, e error, history []string) {
+	var pos Pos
+	switch err := e.(type) {
+	case EvalError:
+		pos = err.Pos
+	case ParseError:
+		pos = err.Pos
+	}
+	if !pos.IsValid() {
+		return
+	}
+	lines := history
+	if pos.File != replFile {
+		content, err := ioutil.ReadFile(pos.File)
 		if err != nil {
-			printError(err)
+			return
+		}
+		lines = strings.Split(string(content), "\n")
+	}
+	if pos.Line > len(lines) {
+		return
+	}
+	line := []rune(strings.TrimRight(lines[pos.Line-1], "\r"))
+	// the tabs are kept, so that the caret is aligned
+	var indent strings.Builder
+	for i := 0; i < pos.Column-1 && i < len(line); i++ {
+		if line[i] == '\t' {
+			indent.WriteRune('\t')
 		} else {
-			// Semantic Analysis
-			if ok, err := SemanticAnalysis(sexpr); !ok {
-				printError(err)
-			} else {
-				// Eval
-				result := Eval(sexpr)
-				if result.Err != nil {
-					printError(result.Err)
-				} else {
-					fmt.Println(" ", result.Cell, aurora.BrightGreen("✓"))
-				}
+			indent.WriteRune(' ')
+		}
+	}
+	fmt.Fprintln(out, "   ", string(line))
+	fmt.Fprintln(out, "   ", indent.String()+aurora.BrightRed("^").String())
+}
+
+// traceHead and traceTail are how many innermost and outermost frames of
+// one long trace are printed
+const (
+	traceHead = 10
+	traceTail = 5
+)
+
+// printTrace prints the functions which were being applied when the error
+// happened, the innermost first
+func printTrace(out io.Writer, e error) {
+	evalErr, isEvalError := e.(EvalError)
+	if !isEvalError {
+		return
+	}
+	trace := evalErr.Trace()
+	for i, frame := range trace {
+		if len(trace) > traceHead+traceTail && i >= traceHead && i < len(trace)-traceTail {
+			if i == traceHead {
+				fmt.Fprintln(out, "    ...", len(trace)-traceHead-traceTail, "more")
 			}
+			continue
 		}
+		fmt.Fprintln(out, "    at", frame)
 	}
 }
 
-func printError(e error) {
-	fmt.Println(" ", aurora.BrightRed(e), aurora.BrightRed("✗"))
+// printScopingWarning explains the errors of the code which relied on the
+// dynamic scoping of the older versions
+func printScopingWarning(out io.Writer, e error) {
+	if evalErr, isEvalError := e.(EvalError); isEvalError && evalErr.boundByCaller {
+		fmt.Fprintln(out, " ", aurora.BrightYellow("warning: "+evalErr.unboundSymbol+" is bound by one calling function, but lambdas only see the"),
+			aurora.BrightYellow("environment they are defined in: declare it with defvar to bind it dynamically"))
+	}
 }
@@ -0,0 +1,104 @@
+package lisp
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// scheduler runs the parallel evaluations on a fixed number of workers.
+// Submitting never blocks: if every worker is busy the caller is expected to
+// do the work inline, so nested {} forms never oversubscribe the cpus.
+type scheduler struct {
+	jobs chan func(worker int)
+	quit chan struct{}
+	// idle counts the workers which are not running or about to run one job
+	idle int32
+	// stopping is read locked while sending one job and write locked by stop,
+	// so every job sent is in jobs before the workers start quitting
+	stopping sync.RWMutex
+	stopped  bool
+}
+
+func newScheduler(workers int) *scheduler {
+	if workers < 1 {
+		workers = 1
+	}
+	s := &scheduler{
+		// one slot per worker: sending a job reserved by trySpawn never blocks
+		jobs: make(chan func(worker int), workers),
+		quit: make(chan struct{}),
+		idle: int32(workers),
+	}
+	for i := 1; i <= workers; i++ {
+		go s.work(i)
+	}
+	return s
+}
+
+// work runs the jobs, which receive the id of the worker, from 1
+func (s *scheduler) work(worker int) {
+	for {
+		select {
+		case job := <-s.jobs:
+			job(worker)
+			atomic.AddInt32(&s.idle, 1)
+		case <-s.quit:
+			// the jobs sent before stop still run
+			for {
+				select {
+				case job := <-s.jobs:
+					job(worker)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// hasIdleWorkers is a cheap hint: trySpawn can still fail after it returned true
+func (s *scheduler) hasIdleWorkers() bool {
+	return atomic.LoadInt32(&s.idle) > 0
+}
+
+// trySpawn reserves one idle worker for the job and returns true, otherwise
+// returns false without running it. The job runs even if the worker has not
+// started waiting for it yet. After stop it always returns false
+func (s *scheduler) trySpawn(job func(worker int)) bool {
+	if !s.hasIdleWorkers() {
+		return false
+	}
+	s.stopping.RLock()
+	defer s.stopping.RUnlock()
+	if s.stopped {
+		return false
+	}
+	for {
+		idle := atomic.LoadInt32(&s.idle)
+		if idle <= 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&s.idle, idle, idle-1) {
+			s.jobs <- job
+			return true
+		}
+	}
+}
+
+// stop makes the workers exit once they finish the jobs already spawned.
+// Calling it more than once does nothing
+func (s *scheduler) stop() {
+	s.stopping.Lock()
+	defer s.stopping.Unlock()
+	if !s.stopped {
+		s.stopped = true
+		close(s.quit)
+	}
+}
+
+// isStopped tells if stop was called
+func (s *scheduler) isStopped() bool {
+	s.stopping.RLock()
+	defer s.stopping.RUnlock()
+	return s.stopped
+}
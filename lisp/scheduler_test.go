@@ -0,0 +1,64 @@
+package lisp
+
+import (
+	"sync"
+	"testing"
+)
+
+// every worker takes one job as soon as the scheduler is created, even if it
+// has not started waiting for the jobs yet
+func TestTrySpawnFillsEveryWorker(t *testing.T) {
+	const workers = 4
+	for run := 0; run < 100; run++ {
+		s := newScheduler(workers)
+		release := make(chan struct{})
+		var started sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			started.Add(1)
+			if !s.trySpawn(func(worker int) {
+				started.Done()
+				<-release
+			}) {
+				t.Fatalf("run %v: job %v not spawned with %v idle workers", run, i, workers-i)
+			}
+		}
+		started.Wait()
+		if s.trySpawn(func(worker int) {}) {
+			t.Fatalf("run %v: job spawned with every worker busy", run)
+		}
+		close(release)
+		s.stop()
+	}
+}
+
+// the jobs spawned before stop still run, none is spawned afterwards
+func TestStopRunsTheSpawnedJobs(t *testing.T) {
+	for run := 0; run < 100; run++ {
+		s := newScheduler(4)
+		var done sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			done.Add(1)
+			if !s.trySpawn(func(worker int) { done.Done() }) {
+				t.Fatalf("run %v: job %v not spawned", run, i)
+			}
+		}
+		s.stop()
+		done.Wait()
+		if s.trySpawn(func(worker int) {}) {
+			t.Fatalf("run %v: job spawned after stop", run)
+		}
+	}
+}
+
+func TestEvalAfterClose(t *testing.T) {
+	interp := NewInterpreter(Config{Workers: 2})
+	sexpression, err := interp.Parse("{+ 1 2}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	interp.Close()
+	interp.Close()
+	if result := interp.Eval(sexpression); result.Err != (ClosedError{}) {
+		t.Fatalf("got %v, want one ClosedError", result)
+	}
+}
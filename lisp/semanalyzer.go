@@ -30,7 +30,7 @@ func containsSideEffects(c Cell) error {
 		}
 		return nil
 	default:
-		if lisp.hasSideEffect(cell) {
+		if hasSideEffect(cell) {
 			return &SemanticError{fmt.Sprintf("%v", cell)}
 		}
 		return nil
@@ -0,0 +1,110 @@
+package lisp
+
+import (
+	"container/heap"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+// forkJoinGraph is the graph of the steps of one evaluation, with the time
+// each one took. The nodes are numbered in the order the sequential
+// evaluation creates them, so the same program builds the same graph at
+// every run, only the costs change
+type forkJoinGraph struct {
+	costs []time.Duration
+	deps  [][]int
+}
+
+// add appends one node, returning its number
+func (g *forkJoinGraph) add(cost time.Duration, deps []int) int {
+	g.costs = append(g.costs, cost)
+	g.deps = append(g.deps, deps)
+	return len(g.costs) - 1
+}
+
+// fingerprint identifies the shape of the graph, ignoring the costs
+func (g *forkJoinGraph) fingerprint() uint64 {
+	h := fnv.New64a()
+	for node, deps := range g.deps {
+		fmt.Fprint(h, node, deps)
+	}
+	return h.Sum64()
+}
+
+// simulate returns the time a greedy scheduler takes to run the graph on
+// the given number of cpus: whenever one cpu is free it runs the ready step
+// which became ready first
+func (g *forkJoinGraph) simulate(cpus int) time.Duration {
+	successors := make([][]int, len(g.costs))
+	waiting := make([]int, len(g.costs))
+	var ready []int
+	for node, deps := range g.deps {
+		waiting[node] = len(deps)
+		for _, dep := range deps {
+			successors[dep] = append(successors[dep], node)
+		}
+		if len(deps) == 0 {
+			ready = append(ready, node)
+		}
+	}
+	var now time.Duration
+	running := &runningSteps{}
+	for len(ready) > 0 || running.Len() > 0 {
+		for running.Len() < cpus && len(ready) > 0 {
+			node := ready[0]
+			ready = ready[1:]
+			heap.Push(running, runningStep{node, now + g.costs[node]})
+		}
+		finished := heap.Pop(running).(runningStep)
+		now = finished.end
+		for _, successor := range successors[finished.node] {
+			waiting[successor]--
+			if waiting[successor] == 0 {
+				ready = append(ready, successor)
+			}
+		}
+	}
+	return now
+}
+
+type runningStep struct {
+	node int
+	end  time.Duration
+}
+
+// runningSteps is a heap of the steps being run, the first to end on top
+type runningSteps []runningStep
+
+func (r runningSteps) Len() int { return len(r) }
+func (r runningSteps) Less(i, j int) bool {
+	return r[i].end < r[j].end || (r[i].end == r[j].end && r[i].node < r[j].node)
+}
+func (r runningSteps) Swap(i, j int)       { r[i], r[j] = r[j], r[i] }
+func (r *runningSteps) Push(x interface{}) { *r = append(*r, x.(runningStep)) }
+func (r *runningSteps) Pop() interface{} {
+	old := *r
+	last := old[len(old)-1]
+	*r = old[:len(old)-1]
+	return last
+}
+
+// simulationReport prints the speedup of the graph on 1, 2, 4 ... 64 cpus
+func simulationReport(out io.Writer, g *forkJoinGraph, work, span time.Duration) {
+	const maxCpus = 64
+	fmt.Fprintf(out, "graph: %v steps, shape %016x\n", len(g.costs), g.fingerprint())
+	fmt.Fprintln(out, "work:", roundDuration(work), " span:", roundDuration(span))
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "cpus\ttime\tspeedup\t")
+	for cpus := 1; cpus <= maxCpus; cpus *= 2 {
+		elapsed := g.simulate(cpus)
+		speedup := 1.0
+		if elapsed > 0 {
+			speedup = float64(work) / float64(elapsed)
+		}
+		fmt.Fprintf(w, "%v\t%v\t%.2f\t\n", cpus, roundDuration(elapsed), speedup)
+	}
+	w.Flush()
+}
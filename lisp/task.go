@@ -0,0 +1,153 @@
+package lisp
+
+import "context"
+
+// task is one strand of evaluation: eval, apply and the builtins receive the
+// task they are running in. Every argument spawned by a {} form runs in a
+// child task of the one that forked it, so cancelling a task stops its whole
+// subtree.
+type task struct {
+	ctx context.Context
+	// root is the context of the whole evaluation the task belongs to
+	root   context.Context
+	interp *Interpreter
+	// globals is the snapshot of the global environment seen by the task
+	globals *globalSnapshot
+	// dynamic binds the special symbols
+	dynamic *environmentEntry
+	// profiler is nil unless profiling
+	profiler *profiler
+	// tracer is nil unless tracing
+	tracer *tracer
+	// worker is the thread of the trace which runs the task: the id of the
+	// worker, from 1, or one negative id for each evaluation started outside
+	// of the workers. Only set when tracing
+	worker int
+	// strand is nil unless measuring the work and the span
+	strand *strand
+}
+
+func newRootTask(ctx context.Context, interp *Interpreter) *task {
+	tr := interp.replTracer()
+	return &task{ctx: ctx, root: ctx, interp: interp, globals: interp.globalEnv.snapshot(), profiler: interp.replProfiler(), tracer: tr, worker: tr.newEvaluation()}
+}
+
+func (t *task) lang() *language {
+	return t.interp.lang
+}
+
+// fork returns one child task and the function that cancels it, together
+// with all its descendants
+func (t *task) fork() (*task, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(t.ctx)
+	child := *t
+	child.ctx = ctx
+	return &child, cancel
+}
+
+// trySpawn runs the job on one idle worker, like scheduler.trySpawn, but it
+// always fails in sequential mode and while measuring the work and the span:
+// the caller then evaluates inline
+func (t *task) trySpawn(job func(worker int)) bool {
+	return t.strand == nil && !t.interp.IsSequential() && t.interp.sched.trySpawn(job)
+}
+
+func (t *task) hasIdleWorkers() bool {
+	return t.strand == nil && !t.interp.IsSequential() && t.interp.sched.hasIdleWorkers()
+}
+
+// withProfiler returns the task which profiles the {} forms it evaluates
+func (t *task) withProfiler(prof *profiler) *task {
+	profiled := *t
+	profiled.profiler = prof
+	return &profiled
+}
+
+// withTracer returns the task which traces the applications and {} forms it evaluates
+func (t *task) withTracer(tr *tracer) *task {
+	traced := *t
+	traced.tracer = tr
+	if t.worker <= 0 {
+		traced.worker = tr.newEvaluation()
+	}
+	return &traced
+}
+
+// withStrand returns the task which measures the work and the span in the strand
+func (t *task) withStrand(s *strand) *task {
+	measured := *t
+	measured.strand = s
+	return &measured
+}
+
+// onWorker returns the task run by the worker, t itself if not tracing
+func (t *task) onWorker(worker int) *task {
+	if t.tracer == nil || t.worker == worker {
+		return t
+	}
+	moved := *t
+	moved.worker = worker
+	return &moved
+}
+
+// branch returns one copy of t for one parallel branch, cancelled together
+// with t: the definitions made by the branch change only its own task
+func (t *task) branch() *task {
+	copied := *t
+	return &copied
+}
+
+// detach returns one task that is not cancelled together with t, but only
+// when the whole evaluation is cancelled or returns. Futures run in detached
+// tasks since their value can be touched after the task that created them
+// returned
+func (t *task) detach() *task {
+	detached := *t
+	detached.ctx = t.root
+	return &detached
+}
+
+// define binds the value to the symbol in the global environment, the
+// definition is visible to the task from now on
+func (t *task) define(sym string, value Cell) {
+	t.globals = t.interp.globalEnv.define(sym, value)
+}
+
+// withGlobals returns the task which sees the last snapshot of the global environment
+func (t *task) withGlobals() *task {
+	updated := *t
+	updated.globals = t.interp.globalEnv.snapshot()
+	return &updated
+}
+
+// withDynamic returns the task which evaluates with the given dynamic
+// environment, it is t itself if the environment does not change
+func (t *task) withDynamic(dynamic *environmentEntry) *task {
+	if dynamic == t.dynamic {
+		return t
+	}
+	scoped := *t
+	scoped.dynamic = dynamic
+	return &scoped
+}
+
+// cancelled is cheap enough to be checked at every evaluation step
+func (t *task) cancelled() bool {
+	select {
+	case <-t.ctx.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// interruption returns the error that stopped the task, nil if it can go on
+func (t *task) interruption() error {
+	if !t.cancelled() {
+		return nil
+	}
+	if t.ctx.Err() == context.DeadlineExceeded {
+		return TimeoutError{}
+	}
+	return CancelledError{}
+}
@@ -1,8 +1,13 @@
 package lisp
 
 import (
+	"bufio"
+	"fmt"
+	"io"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf16"
 )
 
 type tokenType int
@@ -18,6 +23,9 @@ const (
 	tokStr           tokenType = 7
 	tokOpenParallel  tokenType = 8
 	tokCloseParallel tokenType = 9
+	tokQuasiquote    tokenType = 10
+	tokUnquote       tokenType = 11
+	tokUnquoteSplice tokenType = 12
 )
 
 const (
@@ -27,6 +35,9 @@ const (
 	openParParallelChar  = '{'
 	closeParParallelChar = '}'
 	quoteChar            = '\''
+	quasiquoteChar       = '`'
+	unquoteChar          = ','
+	spliceChar           = '@'
 )
 
 var atomicCharTokens = map[rune]bool{
@@ -36,6 +47,8 @@ var atomicCharTokens = map[rune]bool{
 	openParParallelChar:  true,
 	closeParParallelChar: true,
 	quoteChar:            true,
+	quasiquoteChar:       true,
+	unquoteChar:          true,
 }
 
 func isAtmoicCharToken(r rune) bool {
@@ -43,10 +56,13 @@ func isAtmoicCharToken(r rune) bool {
 	return ok
 }
 
+// token is one token of the source, numbers keep their literal in str. pos
+// is where the token starts, end where it ends, excluded
 type token struct {
 	typ tokenType
 	str string
-	val int
+	pos Pos
+	end Pos
 }
 
 func (t token) String() string {
@@ -65,114 +81,350 @@ func (t token) String() string {
 		return "."
 	case tokQuote:
 		return "'"
+	case tokQuasiquote:
+		return "`"
+	case tokUnquote:
+		return ","
+	case tokUnquoteSplice:
+		return ",@"
 	case tokSym:
 		return t.str
 	case tokNum:
-		return strconv.Itoa(t.val)
+		return t.str
 	case tokStr:
-		return "\"" + t.str + "\""
+		return escapeString(t.str)
 	default:
 		return ""
 	}
 }
 
-// tokenize produces an array fo tokens
-func tokenize(source string) []token {
-	tok, rest := readOneToken(removeComments(source))
-	var result []token
-	for (tok.typ) != tokNone {
-		result = append(result, tok)
-		tok, rest = readOneToken(rest)
+// eof is returned by the scanner at the end of the source
+const eof rune = -1
+
+// scanner reads the runes of the source as they are needed, tracking their
+// position. The runes peeked but not advanced yet are kept in ahead
+type scanner struct {
+	reader *bufio.Reader
+	ahead  []rune
+	pos    Pos
+	// err is the error reading the source, the source ends there
+	err error
+}
+
+func newScanner(source io.Reader, start Pos) *scanner {
+	return &scanner{reader: bufio.NewReader(source), ahead: make([]rune, 0, 2), pos: start}
+}
+
+// lookAhead returns the rune n runes after the next one, reading the source
+// if needed
+func (s *scanner) lookAhead(n int) rune {
+	for len(s.ahead) <= n {
+		if s.err != nil {
+			return eof
+		}
+		r, _, err := s.reader.ReadRune()
+		if err != nil {
+			if err != io.EOF {
+				s.err = err
+			}
+			return eof
+		}
+		s.ahead = append(s.ahead, r)
 	}
-	return result
+	return s.ahead[n]
 }
 
-func removeComments(source string) string {
-	if source == "" {
-		return ""
+func (s *scanner) peek() rune {
+	return s.lookAhead(0)
+}
+
+// peekNext returns the rune after the one returned by peek
+func (s *scanner) peekNext() rune {
+	if s.peek() == eof {
+		return eof
 	}
-	if source[0] != ';' {
-		return string(source[0]) + removeComments(source[1:])
+	return s.lookAhead(1)
+}
+
+func (s *scanner) advance() rune {
+	r := s.peek()
+	if r == eof {
+		return eof
+	}
+	s.ahead = append(s.ahead[:0], s.ahead[1:]...)
+	if r == '\n' {
+		s.pos.Line++
+		s.pos.Column = 1
+	} else {
+		s.pos.Column++
 	}
-	for index, r := range source {
-		if r == '\n' {
-			return removeComments(source[index+1:])
+	return r
+}
+
+// skipBlanks skips the blanks and the comments, from one semicolon to the end
+// of the line
+func (s *scanner) skipBlanks() {
+	for {
+		switch r := s.peek(); {
+		case isBlank(r):
+			s.advance()
+		case r == ';':
+			for r := s.peek(); r != '\n' && r != eof; r = s.peek() {
+				s.advance()
+			}
+		default:
+			return
 		}
 	}
-	return ""
-}
-
-// returns the token and the remaining string
-func readOneToken(source string) (token, string) {
-	if source == "" {
-		return token{typ: tokNone}, source
-	}
-	nextChar, index := firstChar(source)
-	if index < 0 {
-		return token{typ: tokNone}, source
-	} else if nextChar == openParChar {
-		return token{typ: tokOpen}, source[index+1:]
-	} else if nextChar == closeParChar {
-		return token{typ: tokClose}, source[index+1:]
-	} else if nextChar == openParParallelChar {
-		return token{typ: tokOpenParallel}, source[index+1:]
-	} else if nextChar == closeParParallelChar {
-		return token{typ: tokCloseParallel}, source[index+1:]
-	} else if nextChar == dotChar {
-		return token{typ: tokDot}, source[index+1:]
-	} else if nextChar == quoteChar {
-		return token{typ: tokQuote}, source[index+1:]
-	} else if nextChar == '"' {
-		rest := source[index+1:]
-		stringResult, rest := readUntilDoubleQuote(rest)
-		return token{typ: tokStr, str: stringResult}, rest
-	} else {
-		firstWord, rest := firstWordOrNumber(source)
-		if num, err := strconv.Atoi(firstWord); err == nil {
-			// it's a num
-			return token{typ: tokNum, val: num}, rest
+}
+
+// isBlank tells if the rune separates the tokens: the Unicode white spaces,
+// so also tabs and the carriage returns of the \r\n line endings
+func isBlank(r rune) bool {
+	return r != eof && unicode.IsSpace(r)
+}
+
+// isSymbolChar tells if the rune can be part of one symbol or number: the
+// printable runes which are not blanks or tokens on their own, and do not
+// start one string or comment
+func isSymbolChar(r rune) bool {
+	return r != eof && unicode.IsGraphic(r) && !isBlank(r) && !isAtmoicCharToken(r) && r != '"' && r != ';'
+}
+
+// readToken returns the next token, one tokNone at the end of the source
+func (s *scanner) readToken() (token, error) {
+	s.skipBlanks()
+	tok := token{pos: s.pos}
+	switch r := s.peek(); {
+	case r == eof:
+		if s.err != nil {
+			return tok, ParseError{err: "error reading the source: " + s.err.Error(), Pos: tok.pos}
+		}
+		tok.typ = tokNone
+	case r == openParChar:
+		s.advance()
+		tok.typ = tokOpen
+	case r == closeParChar:
+		s.advance()
+		tok.typ = tokClose
+	case r == openParParallelChar:
+		s.advance()
+		tok.typ = tokOpenParallel
+	case r == closeParParallelChar:
+		s.advance()
+		tok.typ = tokCloseParallel
+	case r == dotChar && !isDecimalPoint("", s.peekNext()):
+		s.advance()
+		tok.typ = tokDot
+	case r == quoteChar:
+		s.advance()
+		tok.typ = tokQuote
+	case r == quasiquoteChar:
+		s.advance()
+		tok.typ = tokQuasiquote
+	case r == unquoteChar:
+		s.advance()
+		tok.typ = tokUnquote
+		if s.peek() == spliceChar {
+			s.advance()
+			tok.typ = tokUnquoteSplice
+		}
+	case r == '"':
+		s.advance()
+		str, err := s.readString(tok.pos)
+		if err != nil {
+			return tok, err
+		}
+		tok.typ, tok.str = tokStr, str
+	case !isSymbolChar(r) && r != dotChar:
+		return tok, ParseError{err: fmt.Sprintf("unexpected character %U", r), Pos: tok.pos}
+	default:
+		word := s.readWord()
+		if isNumberLiteral(word) {
+			tok.typ, tok.str = tokNum, word
+		} else {
+			tok.typ, tok.str = tokSym, word
 		}
-		return token{typ: tokSym, str: firstWord}, rest
 	}
+	tok.end = s.pos
+	return tok, nil
 }
 
-// returns the char and the position in the string of the char
-// returns -1 if the string has no first char
-func firstChar(str string) (byte, int) {
-	for i := 0; i < len(str); i++ {
-		if str[i] != ' ' && str[i] != '\n' {
-			return str[i], i
+// readWord reads one symbol or number
+func (s *scanner) readWord() string {
+	var word strings.Builder
+	for r := s.peek(); isSymbolChar(r) || r == dotChar; r = s.peek() {
+		if r == dotChar && !isDecimalPoint(word.String(), s.peekNext()) {
+			break
 		}
+		word.WriteRune(s.advance())
 	}
-	return 0, -1
+	return word.String()
 }
 
-// resturns the first word or number in the string and the rest of the string
-func firstWordOrNumber(str string) (string, string) {
-	_, wordBeginningIndex := firstChar(str)
-	stringWithoutBlanks := str[wordBeginningIndex:]
-	result := ""
-	for i, r := range stringWithoutBlanks {
-		if r == '\n' || r == ' ' || isAtmoicCharToken(r) {
-			return result, stringWithoutBlanks[i:]
+// readString reads until the double quote closing the string, which is
+// skipped, replacing the escape sequences. start is where the string starts
+func (s *scanner) readString(start Pos) (string, error) {
+	var result strings.Builder
+	for r := s.peek(); r != eof; r = s.peek() {
+		escapePos := s.pos
+		s.advance()
+		switch r {
+		case '"':
+			return result.String(), nil
+		case '\\':
+			if s.peek() == eof {
+				continue
+			}
+			escaped, err := s.readEscape(escapePos)
+			if err != nil {
+				return "", err
+			}
+			result.WriteRune(escaped)
+		default:
+			result.WriteRune(r)
 		}
-		result += string(r)
 	}
-	return result, ""
+	return "", ParseError{err: "string not closed", Pos: start}
 }
 
-// reads until the first double quote in the string and resturns the rest of the string
-func readUntilDoubleQuote(str string) (string, string) {
-	result := ""
-	for i, r := range str {
-		if r == '"' {
-			return result, str[i+1:]
+// escapes are the escape sequences of the strings but \uXXXX, by the rune
+// following the backslash
+var escapes = map[rune]rune{
+	'"':  '"',
+	'\\': '\\',
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+}
+
+// readEscape reads one escape sequence after its backslash, at the position
+// pos, and returns the rune it stands for. The source must not end after the
+// backslash
+func (s *scanner) readEscape(pos Pos) (rune, error) {
+	r := s.advance()
+	if escaped, isEscape := escapes[r]; isEscape {
+		return escaped, nil
+	}
+	if r != 'u' {
+		if !unicode.IsPrint(r) {
+			return 0, ParseError{err: fmt.Sprintf("unknown escape sequence, backslash and %U", r), Pos: pos}
+		}
+		return 0, ParseError{err: "unknown escape sequence \\" + string(r), Pos: pos}
+	}
+	code, err := s.readCodeUnit(pos)
+	if err != nil || !utf16.IsSurrogate(code) {
+		return code, err
+	}
+	// the runes above U+FFFF are written as their UTF-16 surrogate pair
+	if s.peek() == '\\' && s.peekNext() == 'u' {
+		s.advance()
+		s.advance()
+		low, err := s.readCodeUnit(pos)
+		if err != nil {
+			return 0, err
+		}
+		if decoded := utf16.DecodeRune(code, low); decoded != unicode.ReplacementChar {
+			return decoded, nil
+		}
+	}
+	return 0, ParseError{err: fmt.Sprintf("\\u%04X is not one complete surrogate pair", code), Pos: pos}
+}
+
+// readCodeUnit reads the 4 hexadecimal digits after one \u
+func (s *scanner) readCodeUnit(pos Pos) (rune, error) {
+	var hex strings.Builder
+	for i := 0; i < 4; i++ {
+		hex.WriteRune(s.advance())
+	}
+	code, err := strconv.ParseUint(hex.String(), 16, 16)
+	if err != nil {
+		return 0, ParseError{err: "\\u needs 4 hexadecimal digits", Pos: pos}
+	}
+	return rune(code), nil
+}
+
+// escapeString returns the literal of the string, the inverse of readString:
+// the double quotes, the backslashes and the runes which are not printable
+// are escaped
+func escapeString(str string) string {
+	var literal strings.Builder
+	literal.WriteRune('"')
+	for _, r := range str {
+		switch {
+		case r == '"' || r == '\\':
+			literal.WriteRune('\\')
+			literal.WriteRune(r)
+		case r == '\n':
+			literal.WriteString("\\n")
+		case r == '\t':
+			literal.WriteString("\\t")
+		case r == '\r':
+			literal.WriteString("\\r")
+		case !unicode.IsPrint(r) && r <= 0xFFFF:
+			fmt.Fprintf(&literal, "\\u%04X", r)
+		case !unicode.IsPrint(r):
+			high, low := utf16.EncodeRune(r)
+			fmt.Fprintf(&literal, "\\u%04X\\u%04X", high, low)
+		default:
+			literal.WriteRune(r)
+		}
+	}
+	literal.WriteRune('"')
+	return literal.String()
+}
+
+// isNumberLiteral accepts the integers like -12, the rationals like 7/2 and
+// the floats like 3.14, .5 or 1e-9
+func isNumberLiteral(word string) bool {
+	word = withoutSign(word)
+	integer := leadingDigits(word)
+	word = word[len(integer):]
+	if strings.HasPrefix(word, "/") {
+		denominator := word[1:]
+		return integer != "" && denominator != "" && leadingDigits(denominator) == denominator
+	}
+	if strings.HasPrefix(word, string(dotChar)) {
+		fraction := leadingDigits(word[1:])
+		if integer == "" && fraction == "" {
+			return false
 		}
-		result += string(r)
+		word = word[1+len(fraction):]
+	} else if integer == "" {
+		return false
 	}
-	return "", ""
+	if word == "" {
+		return true
+	}
+	if word[0] != 'e' && word[0] != 'E' {
+		return false
+	}
+	exponent := withoutSign(word[1:])
+	return exponent != "" && leadingDigits(exponent) == exponent
 }
 
-func stringContainsDoubleQuotes(str string) bool {
-	return strings.Contains(str, "\"")
+// isDecimalPoint tells if one dot after word, followed by next, is part of
+// one number
+func isDecimalPoint(word string, next rune) bool {
+	word = withoutSign(word)
+	if word == "" {
+		return next >= '0' && next <= '9'
+	}
+	return leadingDigits(word) == word
+}
+
+func withoutSign(word string) string {
+	if strings.HasPrefix(word, "+") || strings.HasPrefix(word, "-") {
+		return word[1:]
+	}
+	return word
+}
+
+func leadingDigits(str string) string {
+	for i, r := range str {
+		if r < '0' || r > '9' {
+			return str[:i]
+		}
+	}
+	return str
 }
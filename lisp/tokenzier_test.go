@@ -0,0 +1,48 @@
+package lisp
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// the runes survive printing one string and reading it back: the control
+// characters, the ones around the surrogates and the ones above U+FFFF,
+// printable or not
+func TestEscapeStringRoundTrip(t *testing.T) {
+	interp := NewInterpreter(Config{Workers: 1})
+	defer interp.Close()
+	for _, runes := range [][2]rune{{0, 0x800}, {0xD000, 0x10800}, {0x1F000, 0x20000}, {0xE0000, 0xE1000}, {0x10F000, utf8.MaxRune + 1}} {
+		var str strings.Builder
+		for r := runes[0]; r < runes[1]; r++ {
+			if utf8.ValidRune(r) {
+				str.WriteRune(r)
+			}
+		}
+		literal := escapeString(str.String())
+		read, err := interp.Parse(literal)
+		if err != nil {
+			t.Fatalf("from %U: %v", runes[0], err)
+		}
+		if read.(*stringCell).Str != str.String() {
+			t.Fatalf("from %U: the string read back is different", runes[0])
+		}
+	}
+}
+
+func TestSurrogateEscapes(t *testing.T) {
+	interp := NewInterpreter(Config{Workers: 1})
+	defer interp.Close()
+	read, err := interp.Parse(`"\uD83D\uDE00 \uFFFF"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "\U0001F600 \uFFFF"; read.(*stringCell).Str != want {
+		t.Errorf("got %q, want %q", read.(*stringCell).Str, want)
+	}
+	for _, literal := range []string{`"\uD800"`, `"\uDC00"`, `"\uDE00\uD83D"`, `"\uD83DA"`, `"\uD83D\n"`, `"\uD83D\uDE0"`} {
+		if _, err := interp.Parse(literal); err == nil {
+			t.Errorf("%v: no error", literal)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package lisp
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tracer records the spans of the applications of the user functions and of
+// the arguments of {} forms, in the Chrome Trace Event format. Every method
+// works on a nil tracer, doing nothing
+type tracer struct {
+	start  time.Time
+	mutex  sync.Mutex
+	events []traceEvent
+	// evaluations counts the evaluations started outside of the workers
+	evaluations int32
+}
+
+// traceEvent is one complete event of the Chrome Trace Event format. The
+// times are in microseconds, tid is the worker which ran the span, or minus
+// the number of the evaluation if it ran in the goroutine that started it
+type traceEvent struct {
+	Name string            `json:"name"`
+	Cat  string            `json:"cat,omitempty"`
+	Ph   string            `json:"ph"`
+	Ts   float64           `json:"ts"`
+	Dur  float64           `json:"dur,omitempty"`
+	Pid  int               `json:"pid"`
+	Tid  int               `json:"tid"`
+	Args map[string]string `json:"args,omitempty"`
+}
+
+// traceSpan is one span being recorded, the zero value is not recorded
+type traceSpan struct {
+	tracer *tracer
+	name   string
+	cat    string
+	worker int
+	start  time.Time
+}
+
+func newTracer() *tracer {
+	return &tracer{start: time.Now()}
+}
+
+// newEvaluation returns the thread of the trace of one evaluation started
+// outside of the workers, 0 on a nil tracer
+func (tr *tracer) newEvaluation() int {
+	if tr == nil {
+		return 0
+	}
+	return -int(atomic.AddInt32(&tr.evaluations, 1))
+}
+
+func (tr *tracer) begin(name, cat string, worker int) traceSpan {
+	if tr == nil {
+		return traceSpan{}
+	}
+	return traceSpan{tr, name, cat, worker, time.Now()}
+}
+
+// beginBranch begins the span of one argument of one {} form, without
+// printing it on a nil tracer
+func (tr *tracer) beginBranch(argument Cell, worker int) traceSpan {
+	if tr == nil {
+		return traceSpan{}
+	}
+	return tr.begin(shortCell(argument), "branch", worker)
+}
+
+func (s traceSpan) end() {
+	if s.tracer == nil {
+		return
+	}
+	event := traceEvent{
+		Name: s.name,
+		Cat:  s.cat,
+		Ph:   "X",
+		Ts:   microseconds(s.start.Sub(s.tracer.start)),
+		Dur:  microseconds(time.Since(s.start)),
+		Pid:  1,
+		Tid:  s.worker,
+	}
+	s.tracer.mutex.Lock()
+	s.tracer.events = append(s.tracer.events, event)
+	s.tracer.mutex.Unlock()
+}
+
+func microseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Microsecond)
+}
+
+// writeFile writes the trace, that can be opened in chrome://tracing or in
+// https://ui.perfetto.dev
+func (tr *tracer) writeFile(path string) error {
+	tr.mutex.Lock()
+	events := append([]traceEvent(nil), tr.events...)
+	tr.mutex.Unlock()
+
+	seen := make(map[int]bool)
+	var workers []int
+	for _, event := range events {
+		if !seen[event.Tid] {
+			seen[event.Tid] = true
+			workers = append(workers, event.Tid)
+		}
+	}
+	sort.Ints(workers)
+	for _, worker := range workers {
+		name := fmt.Sprintf("evaluation %v", -worker)
+		if worker > 0 {
+			name = fmt.Sprintf("worker %v", worker)
+		}
+		events = append(events, traceEvent{
+			Name: "thread_name",
+			Ph:   "M",
+			Pid:  1,
+			Tid:  worker,
+			Args: map[string]string{"name": name},
+		})
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	encodeErr := json.NewEncoder(file).Encode(struct {
+		TraceEvents     []traceEvent `json:"traceEvents"`
+		DisplayTimeUnit string       `json:"displayTimeUnit"`
+	}{events, "ms"})
+	if closeErr := file.Close(); encodeErr == nil {
+		encodeErr = closeErr
+	}
+	return encodeErr
+}
+
+// functionName names the span of one application
+func functionName(function Cell) string {
+	if sym, isSymbol := function.(*symbolCell); isSymbol {
+		return sym.Sym
+	}
+	return "λ"
+}
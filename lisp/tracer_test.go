@@ -0,0 +1,42 @@
+package lisp
+
+import (
+	"sync"
+	"testing"
+)
+
+// every argument of one {} form has its span, even the ones evaluated inline,
+// and the evaluations started by different goroutines have different threads
+func TestTraceSpans(t *testing.T) {
+	interp := NewInterpreter(Config{Workers: 1})
+	defer interp.Close()
+	sexpression := parseSource(t, interp, "{+ (car '(1)) (car '(2)) (car '(3))}")
+	interp.StartTrace()
+	tr := interp.replTracer()
+	var evaluations sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		evaluations.Add(1)
+		go func() {
+			defer evaluations.Done()
+			interp.Eval(sexpression)
+		}()
+	}
+	evaluations.Wait()
+
+	branches := 0
+	evaluationThreads := make(map[int]bool)
+	for _, event := range tr.events {
+		if event.Cat == "branch" {
+			branches++
+		}
+		if event.Tid <= 0 {
+			evaluationThreads[event.Tid] = true
+		}
+	}
+	if branches != 6 {
+		t.Errorf("got %v branch spans, want 6", branches)
+	}
+	if len(evaluationThreads) != 2 || evaluationThreads[0] {
+		t.Errorf("got the evaluation threads %v, want -1 and -2", evaluationThreads)
+	}
+}
@@ -0,0 +1,128 @@
+package lisp
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+)
+
+// strand is one sequence of evaluation steps of the work-span analysis: the
+// {} forms fork one strand for each argument, and join them when all the
+// arguments are evaluated. While measuring everything is evaluated
+// sequentially, so that the time of each strand is not affected by the others
+type strand struct {
+	// work is shared by all the strands of one analysis
+	work *time.Duration
+	// span is the length of the longest path of the fork/join graph which ends
+	// in the strand
+	span    time.Duration
+	resumed time.Time
+	// graph is nil unless simulating: then every step between one resume and
+	// the following pause is one node of the graph
+	graph *forkJoinGraph
+	// deps are the nodes that the next step of the strand depends on
+	deps []int
+}
+
+func newStrand() *strand {
+	return &strand{work: new(time.Duration), resumed: time.Now()}
+}
+
+// pause adds the time elapsed since the strand resumed
+func (s *strand) pause() {
+	elapsed := time.Since(s.resumed)
+	*s.work += elapsed
+	s.span += elapsed
+	if s.graph != nil {
+		s.deps = []int{s.graph.add(elapsed, s.deps)}
+	}
+}
+
+func (s *strand) resume() {
+	s.resumed = time.Now()
+}
+
+// child returns one strand starting from the current span of s
+func (s *strand) child() *strand {
+	return &strand{work: s.work, span: s.span, resumed: time.Now(), graph: s.graph, deps: s.deps}
+}
+
+// join waits for one strand which ended
+func (s *strand) join(ended *strand) {
+	s.pause()
+	if ended.span > s.span {
+		s.span = ended.span
+	}
+	if s.graph != nil {
+		s.deps = append(s.deps[:len(s.deps):len(s.deps)], ended.deps...)
+	}
+	s.resume()
+}
+
+// forkJoin joins the strands of the branches of one parallel form. Its
+// methods do nothing if the task is not measuring, so they can be called
+// at every fork point
+type forkJoin struct {
+	parent *strand
+	span   time.Duration
+	// deps are the last nodes of the branches, when simulating
+	deps []int
+}
+
+// forkStrands starts one fork point of t, nil if t is not measuring
+func (t *task) forkStrands() *forkJoin {
+	if t.strand == nil {
+		return nil
+	}
+	t.strand.pause()
+	return &forkJoin{parent: t.strand, span: t.strand.span}
+}
+
+// branch returns the task which evaluates one branch in its own strand
+func (fj *forkJoin) branch(t *task) *task {
+	if fj == nil {
+		return t
+	}
+	return t.withStrand(fj.parent.child())
+}
+
+// done ends the strand of the branch
+func (fj *forkJoin) done(branchTask *task) {
+	if fj == nil {
+		return
+	}
+	branchTask.strand.pause()
+	if branchTask.strand.span > fj.span {
+		fj.span = branchTask.strand.span
+	}
+	fj.deps = append(fj.deps, branchTask.strand.deps...)
+}
+
+// join makes the parent strand continue after the longest branch
+func (fj *forkJoin) join() {
+	if fj == nil {
+		return
+	}
+	fj.parent.span = fj.span
+	if fj.parent.graph != nil && len(fj.deps) > 0 {
+		fj.parent.deps = fj.deps
+	}
+	fj.parent.resume()
+}
+
+// workSpanReport prints the analysis of one evaluation
+func workSpanReport(out io.Writer, work, span time.Duration) {
+	cpus := runtime.NumCPU()
+	parallelism := 1.0
+	if span > 0 {
+		parallelism = float64(work) / float64(span)
+	}
+	// by Brent's theorem a greedy scheduler takes at most (work - span)/P + span on P cpus
+	predicted := 1.0
+	if bound := float64(work-span)/float64(cpus) + float64(span); bound > 0 {
+		predicted = float64(work) / bound
+	}
+	fmt.Fprintln(out, "work:", roundDuration(work), " span:", roundDuration(span))
+	fmt.Fprintf(out, "parallelism: %.2f  predicted speedup on %v cpus: %.2f\n", parallelism, cpus, predicted)
+}
@@ -1,8 +1,29 @@
 package main
 
-import "github.com/parof/parallellisp/lisp"
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/parof/parallellisp/lisp"
+)
 
 func main() {
+	workers := flag.Int("workers", runtime.NumCPU(), "number of workers evaluating {} arguments in parallel")
+	trace := flag.String("trace", "", "write the trace of the evaluations to the file, in the Chrome Trace Event format")
+	sequential := flag.Bool("sequential", false, "evaluate {} forms sequentially, to debug parallel programs")
+	flag.Parse()
 	// runtime.GOMAXPROCS(1)
-	lisp.Repl()
+	interp := lisp.NewInterpreter(lisp.Config{Workers: *workers, Sequential: *sequential})
+	defer interp.Close()
+	if *trace != "" {
+		interp.StartTrace()
+		defer func() {
+			if err := interp.StopTrace(*trace); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}()
+	}
+	interp.Repl()
 }